@@ -7,27 +7,139 @@ import (
 	"github.com/fakubwoy/go-file-share/internal/auth"
 	"github.com/fakubwoy/go-file-share/internal/config"
 	"github.com/fakubwoy/go-file-share/internal/handlers"
+	"github.com/fakubwoy/go-file-share/internal/mail"
+	"github.com/fakubwoy/go-file-share/internal/models"
 	"github.com/fakubwoy/go-file-share/internal/storage"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
 
-func SetupRoutes(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) *mux.Router {
+func SetupRoutes(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet, oidc *auth.OIDCProvider, mailer mail.Mailer, policy *auth.PasswordPolicy, storage storage.Storage) *mux.Router {
 	r := mux.NewRouter()
 
-	r.HandleFunc("/register", handlers.RegisterHandler(db, cfg)).Methods("POST")
-	r.HandleFunc("/login", handlers.LoginHandler(db, cfg)).Methods("POST")
+	r.HandleFunc("/register", handlers.RegisterHandler(db, rdb, cfg, keys, mailer, policy)).Methods("POST")
+	r.HandleFunc("/login", handlers.LoginHandler(db, rdb, cfg, keys)).Methods("POST")
+	r.HandleFunc("/login/2fa", handlers.TwoFactorLoginHandler(db, rdb, cfg, keys)).Methods("POST")
+	r.HandleFunc("/login/2fa/enroll", handlers.TwoFactorLoginEnrollHandler(db, rdb, cfg)).Methods("POST")
+	r.HandleFunc("/token/refresh", handlers.RefreshTokenHandler(db, rdb, cfg, keys)).Methods("POST")
+	r.HandleFunc("/logout", handlers.LogoutHandler(db, rdb, cfg)).Methods("POST")
+	r.HandleFunc("/email/verify", handlers.VerifyEmailPageHandler(db)).Methods("GET")
+	r.HandleFunc("/email/verify", handlers.VerifyEmailHandler(db)).Methods("POST")
+	r.HandleFunc("/email/verify/resend", handlers.ResendVerificationHandler(db, rdb, cfg, mailer)).Methods("POST")
+	r.HandleFunc("/password/forgot", handlers.ForgotPasswordHandler(db, rdb, cfg, mailer)).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPasswordPageHandler()).Methods("GET")
+	r.HandleFunc("/password/reset", handlers.ResetPasswordHandler(db, rdb, cfg, policy)).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", handlers.JWKSHandler(keys)).Methods("GET")
+	if oidc != nil {
+		r.HandleFunc("/auth/oidc/login", handlers.OIDCLoginHandler(rdb, oidc)).Methods("GET")
+		r.HandleFunc("/auth/oidc/callback", handlers.OIDCCallbackHandler(db, rdb, cfg, keys, oidc)).Methods("GET")
+	}
 
 	fileRouter := r.PathPrefix("/files").Subrouter()
-	fileRouter.Use(auth.AuthMiddleware(cfg))
+	fileRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
 
-	fileRouter.HandleFunc("", handlers.ListFilesHandler(db, rdb)).Methods("GET")
-	fileRouter.HandleFunc("", handlers.UploadHandler(db, cfg, storage, rdb)).Methods("POST")
-	fileRouter.HandleFunc("/search", handlers.SearchFilesHandler(db, rdb)).Methods("GET")
-	fileRouter.HandleFunc("/{id}/share", handlers.ShareFileHandler(db, cfg, storage)).Methods("POST")
-	fileRouter.HandleFunc("/{id}", handlers.DeleteFileHandler(db, rdb)).Methods("DELETE")
+	fileRouter.Handle("", auth.Scoped(models.ScopeFilesRead, handlers.ListFilesHandler(db, rdb))).Methods("GET")
+	fileRouter.Handle("", auth.Scoped(models.ScopeFilesWrite, handlers.UploadHandler(db, cfg, storage, rdb))).Methods("POST")
+	fileRouter.Handle("/search", auth.Scoped(models.ScopeFilesRead, handlers.SearchFilesHandler(db, rdb))).Methods("GET")
+	fileRouter.Handle("/archive", auth.Scoped(models.ScopeFilesRead, handlers.DownloadArchiveHandler(db, cfg, storage))).Methods("POST")
+	fileRouter.Handle("/recent", auth.Scoped(models.ScopeFilesRead, handlers.RecentFilesHandler(db))).Methods("GET")
+	fileRouter.Handle("/starred", auth.Scoped(models.ScopeFilesRead, handlers.StarredFilesHandler(db))).Methods("GET")
+	fileRouter.Handle("/{id}/share", auth.Scoped(models.ScopeSharesCreate, handlers.ShareFileHandler(db, cfg, storage))).Methods("POST")
+	fileRouter.HandleFunc("/{id}/shares", handlers.ListSharesHandler(db, cfg)).Methods("GET")
+	fileRouter.HandleFunc("/{id}/shares/{shareID}", handlers.UpdateShareHandler(db, cfg)).Methods("PATCH")
+	fileRouter.HandleFunc("/{id}/shares/{shareID}", handlers.RevokeShareHandler(db)).Methods("DELETE")
+	fileRouter.HandleFunc("/{id}/shares/{shareID}/analytics", handlers.ShareAnalyticsHandler(db)).Methods("GET")
+	fileRouter.HandleFunc("/{id}/shares/{shareID}/accesses", handlers.ShareAccessLogHandler(db)).Methods("GET")
+	fileRouter.HandleFunc("/{id}/analytics", handlers.FileShareAnalyticsHandler(db)).Methods("GET")
+	fileRouter.HandleFunc("/{id}/permissions", handlers.GrantFileHandler(db)).Methods("POST")
+	fileRouter.HandleFunc("/{id}/permissions", handlers.ListFileGrantsHandler(db)).Methods("GET")
+	fileRouter.HandleFunc("/{id}/permissions/{grantID}", handlers.RevokeFileGrantHandler(db)).Methods("DELETE")
+	fileRouter.Handle("/{id}/download", auth.Scoped(models.ScopeFilesRead, handlers.DownloadFileHandler(db, storage))).Methods("GET")
+	fileRouter.HandleFunc("/{id}/star", handlers.StarFileHandler(db)).Methods("PUT")
+	fileRouter.HandleFunc("/{id}/star", handlers.UnstarFileHandler(db)).Methods("DELETE")
+	fileRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesRead, handlers.GetFileHandler(db))).Methods("GET")
+	fileRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesWrite, handlers.UpdateFileHandler(db, rdb))).Methods("PATCH")
+	fileRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesWrite, handlers.DeleteFileHandler(db, rdb))).Methods("DELETE")
 
-	r.HandleFunc("/share/{token}", handlers.GetSharedFileHandler(db, storage)).Methods("GET")
+	folderRouter := r.PathPrefix("/folders").Subrouter()
+	folderRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
+
+	folderRouter.Handle("", auth.Scoped(models.ScopeFilesRead, handlers.ListFoldersHandler(db))).Methods("GET")
+	folderRouter.Handle("", auth.Scoped(models.ScopeFilesWrite, handlers.CreateFolderHandler(db))).Methods("POST")
+	folderRouter.Handle("/{id}/share", auth.Scoped(models.ScopeSharesCreate, handlers.ShareFolderHandler(db, cfg))).Methods("POST")
+	folderRouter.HandleFunc("/{id}/shares", handlers.ListFolderSharesHandler(db, cfg)).Methods("GET")
+	folderRouter.HandleFunc("/{id}/permissions", handlers.GrantFolderHandler(db)).Methods("POST")
+	folderRouter.HandleFunc("/{id}/permissions", handlers.ListFolderGrantsHandler(db)).Methods("GET")
+	folderRouter.HandleFunc("/{id}/permissions/{grantID}", handlers.RevokeFolderGrantHandler(db)).Methods("DELETE")
+	folderRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesRead, handlers.GetFolderHandler(db))).Methods("GET")
+	folderRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesWrite, handlers.UpdateFolderHandler(db))).Methods("PATCH")
+	folderRouter.Handle("/{id}", auth.Scoped(models.ScopeFilesWrite, handlers.DeleteFolderHandler(db))).Methods("DELETE")
+
+	shareRouter := r.PathPrefix("/shares").Subrouter()
+	shareRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
+
+	shareRouter.HandleFunc("", handlers.ListAllSharesHandler(db, cfg)).Methods("GET")
+	shareRouter.Handle("", auth.Scoped(models.ScopeSharesCreate, handlers.CreateSelectionShareHandler(db, cfg))).Methods("POST")
+	shareRouter.HandleFunc("/{shareID}", handlers.UpdateShareHandler(db, cfg)).Methods("PATCH")
+	shareRouter.HandleFunc("/{shareID}", handlers.RevokeShareHandler(db)).Methods("DELETE")
+	shareRouter.HandleFunc("/{shareID}/analytics", handlers.ShareAnalyticsHandler(db)).Methods("GET")
+	shareRouter.HandleFunc("/{shareID}/accesses", handlers.ShareAccessLogHandler(db)).Methods("GET")
+
+	r.Handle("/shared-with-me", auth.AuthMiddleware(db, rdb, cfg, keys)(handlers.SharedWithMeHandler(db))).Methods("GET")
+
+	meRouter := r.PathPrefix("/me").Subrouter()
+	meRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
+
+	meRouter.HandleFunc("", handlers.GetProfileHandler(db)).Methods("GET")
+	meRouter.HandleFunc("", handlers.UpdateProfileHandler(db)).Methods("PATCH")
+	meRouter.HandleFunc("", handlers.DeleteAccountHandler(db, rdb, cfg, storage)).Methods("DELETE")
+	meRouter.HandleFunc("/email", handlers.ChangeEmailHandler(db, rdb, cfg, mailer)).Methods("POST")
+	meRouter.HandleFunc("/password", handlers.ChangePasswordHandler(db, rdb, cfg, policy)).Methods("POST")
+	meRouter.HandleFunc("/sessions", handlers.ListSessionsHandler(db)).Methods("GET")
+	meRouter.HandleFunc("/sessions", handlers.RevokeSessionsHandler(db, rdb, cfg)).Methods("DELETE")
+	meRouter.HandleFunc("/sessions/{id}", handlers.RevokeSessionHandler(db, rdb, cfg)).Methods("DELETE")
+	meRouter.HandleFunc("/2fa", handlers.TwoFactorStatusHandler(db, cfg)).Methods("GET")
+	meRouter.HandleFunc("/2fa/totp", handlers.StartTOTPHandler(db, cfg)).Methods("POST")
+	meRouter.HandleFunc("/2fa/totp", handlers.DisableTOTPHandler(db, rdb, cfg)).Methods("DELETE")
+	meRouter.HandleFunc("/2fa/totp/confirm", handlers.ConfirmTOTPHandler(db)).Methods("POST")
+	meRouter.HandleFunc("/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler(db, rdb, cfg)).Methods("POST")
+	meRouter.HandleFunc("/api-keys", handlers.ListAPIKeysHandler(db)).Methods("GET")
+	meRouter.HandleFunc("/api-keys", handlers.CreateAPIKeyHandler(db)).Methods("POST")
+	meRouter.HandleFunc("/api-keys/{id}", handlers.RevokeAPIKeyHandler(db)).Methods("DELETE")
+	meRouter.HandleFunc("/share-settings", handlers.GetShareSettingsHandler(db, cfg)).Methods("GET")
+	meRouter.HandleFunc("/share-settings", handlers.UpdateShareSettingsHandler(db, cfg)).Methods("PUT")
+	meRouter.HandleFunc("/notifications", handlers.ListNotificationsHandler(db)).Methods("GET")
+	meRouter.HandleFunc("/notifications/{id}/read", handlers.MarkNotificationReadHandler(db)).Methods("POST")
+
+	uploadRequestRouter := r.PathPrefix("/upload-requests").Subrouter()
+	uploadRequestRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
+
+	uploadRequestRouter.HandleFunc("", handlers.ListUploadRequestsHandler(db, cfg)).Methods("GET")
+	uploadRequestRouter.HandleFunc("", handlers.CreateUploadRequestHandler(db, cfg)).Methods("POST")
+	uploadRequestRouter.HandleFunc("/{id}", handlers.GetUploadRequestHandler(db, cfg)).Methods("GET")
+	uploadRequestRouter.HandleFunc("/{id}", handlers.RevokeUploadRequestHandler(db)).Methods("DELETE")
+
+	searchRouter := r.PathPrefix("/searches").Subrouter()
+	searchRouter.Use(auth.AuthMiddleware(db, rdb, cfg, keys))
+
+	searchRouter.HandleFunc("", handlers.ListSavedSearchesHandler(db)).Methods("GET")
+	searchRouter.HandleFunc("", handlers.CreateSavedSearchHandler(db, rdb)).Methods("POST")
+	searchRouter.HandleFunc("/{id}", handlers.GetSavedSearchHandler(db)).Methods("GET")
+	searchRouter.HandleFunc("/{id}", handlers.UpdateSavedSearchHandler(db, rdb)).Methods("PUT")
+	searchRouter.HandleFunc("/{id}", handlers.DeleteSavedSearchHandler(db, rdb)).Methods("DELETE")
+	searchRouter.HandleFunc("/{id}/files", handlers.RunSavedSearchHandler(db, rdb)).Methods("GET")
+
+	r.HandleFunc("/share/{token}", handlers.SharePageHandler(db, rdb, cfg)).Methods("GET")
+	r.HandleFunc("/share/{token}/preview", handlers.SharePreviewHandler(db, rdb, cfg, storage)).Methods("GET")
+	r.HandleFunc("/share/{token}/download", handlers.ShareDownloadHandler(db, rdb, cfg, storage)).Methods("GET")
+	r.HandleFunc("/share/{token}/files/{fileID}/preview", handlers.SharePreviewHandler(db, rdb, cfg, storage)).Methods("GET")
+	r.HandleFunc("/share/{token}/files/{fileID}/download", handlers.ShareDownloadHandler(db, rdb, cfg, storage)).Methods("GET")
+	r.HandleFunc("/share/{token}/archive", handlers.ShareArchiveHandler(db, rdb, cfg, storage)).Methods("GET")
+	r.HandleFunc("/share/{token}/unlock", handlers.UnlockShareHandler(db, rdb, cfg)).Methods("POST")
+
+	r.HandleFunc("/drop/{token}", handlers.DropPageHandler(db, rdb)).Methods("GET")
+	r.HandleFunc("/drop/{token}", handlers.DropUploadHandler(db, rdb, cfg, storage)).Methods("POST")
+	r.HandleFunc("/drop/{token}/unlock", handlers.DropUnlockHandler(db, rdb, cfg)).Methods("POST")
 
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -8,10 +8,13 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // profile time zones are checked against it, the image has none
 
 	"github.com/fakubwoy/go-file-share/api"
+	"github.com/fakubwoy/go-file-share/internal/auth"
 	"github.com/fakubwoy/go-file-share/internal/config"
 	"github.com/fakubwoy/go-file-share/internal/database"
+	"github.com/fakubwoy/go-file-share/internal/mail"
 	"github.com/fakubwoy/go-file-share/internal/storage"
 	"github.com/fakubwoy/go-file-share/internal/worker"
 	_ "github.com/lib/pq"
@@ -20,6 +23,21 @@ import (
 func main() {
 	cfg := config.LoadConfig()
 
+	keys, err := auth.LoadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load token signing keys: %v", err)
+	}
+
+	policy, err := auth.NewPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up password policy: %v", err)
+	}
+
+	mailer, err := mail.NewMailer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up mail: %v", err)
+	}
+
 	db, err := database.NewPostgresDB(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -45,10 +63,13 @@ func main() {
 		}
 	}
 
-	cleanupWorker := worker.NewCleanupWorker(db, fileStorage, 1*time.Hour)
+	cleanupWorker := worker.NewCleanupWorker(db, fileStorage, 1*time.Hour, cfg.ShareAccessLogRetention)
 	go cleanupWorker.Start()
 
-	router := api.SetupRoutes(db, rdb, cfg, fileStorage)
+	extractionWorker := worker.NewExtractionWorker(db, fileStorage, 30*time.Second)
+	go extractionWorker.Start()
+
+	router := api.SetupRoutes(db, rdb, cfg, keys, auth.NewOIDCProvider(cfg), mailer, policy, fileStorage)
 	server := &http.Server{
 		Addr:    ":" + cfg.ServerPort,
 		Handler: router,
@@ -0,0 +1,313 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying out and
+// testing single sign-on locally. It signs in whoever asks, with the email
+// and name entered on its login form, and must never be exposed publicly.
+//
+//	go run ./cmd/mockoidc -addr :9000 -client-id fileshare
+//
+// and start the server with OIDC_ISSUER_URL=http://localhost:9000 and
+// OIDC_CLIENT_ID=fileshare.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const keyID = "mock-1"
+
+type authCode struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	name          string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	autoApprove  bool
+	email        string
+	name         string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authCode
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>Mock OIDC login</title></head>
+<body style="font-family: sans-serif; max-width: 30em; margin: 3em auto">
+<h1>Mock OIDC login</h1>
+<p>Signing in to <code>{{.ClientID}}</code>. Any email is accepted.</p>
+<form method="post">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<p><label>Email <input name="email" value="{{.Email}}" size="30"></label></p>
+<p><label>Name <input name="name" value="{{.Name}}" size="30"></label></p>
+<p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body></html>
+`))
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// subject derives a stable subject from the email, so signing in with the
+// same email again is the same user.
+func subject(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "mock-" + hex.EncodeToString(sum[:8])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize shows the login form and, once it is submitted (or right away
+// with -auto-approve), sends the browser back to the client with a code.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	params := map[string]string{}
+	for _, k := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[k] = r.Form.Get(k)
+	}
+
+	// Problems with the client or redirect URI can't be reported back to it.
+	if params["client_id"] != p.clientID {
+		http.Error(w, "Unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(params["redirect_uri"])
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	fail := func(code, description string) {
+		q := redirectURI.Query()
+		q.Set("error", code)
+		q.Set("error_description", description)
+		q.Set("state", params["state"])
+		redirectURI.RawQuery = q.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+	if params["response_type"] != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	if !strings.Contains(" "+params["scope"]+" ", " openid ") {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	if params["code_challenge"] == "" || params["code_challenge_method"] != "S256" {
+		fail("invalid_request", "PKCE with S256 is required")
+		return
+	}
+
+	code := &authCode{
+		clientID:      params["client_id"],
+		redirectURI:   params["redirect_uri"],
+		challenge:     params["code_challenge"],
+		nonce:         params["nonce"],
+		email:         p.email,
+		name:          p.name,
+		emailVerified: true,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	if r.Method == http.MethodPost {
+		code.email = strings.TrimSpace(r.PostForm.Get("email"))
+		code.name = strings.TrimSpace(r.PostForm.Get("name"))
+		code.emailVerified = r.PostForm.Get("email_verified") == "true"
+	} else if !p.autoApprove {
+		email := p.email
+		if hint := r.Form.Get("login_hint"); hint != "" {
+			email = hint
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"ClientID": p.clientID, "Params": params, "Email": email, "Name": p.name})
+		return
+	} else if hint := r.Form.Get("login_hint"); hint != "" {
+		code.email = hint
+	}
+
+	value := randomString()
+	p.mu.Lock()
+	p.codes[value] = code
+	p.mu.Unlock()
+
+	q := redirectURI.Query()
+	q.Set("code", value)
+	q.Set("state", params["state"])
+	redirectURI.RawQuery = q.Encode()
+	log.Printf("Signed in %s, redirecting to %s", code.email, code.redirectURI)
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token redeems a code for an ID token, checking the client, redirect URI and
+// PKCE verifier the way a real provider does.
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "malformed form")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || (p.clientSecret != "" && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	p.mu.Lock()
+	code := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if code == nil || time.Now().After(code.expiresAt) || code.clientID != clientID {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri doesn't match")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            subject(code.email),
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": code.emailVerified,
+		"name":           code.name,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL the provider is reached at")
+	clientID := flag.String("client-id", "fileshare", "the only client_id accepted")
+	clientSecret := flag.String("client-secret", "", "client secret to require, none if empty")
+	email := flag.String("email", "dev@example.com", "email prefilled on the login form")
+	name := flag.String("name", "Dev User", "name prefilled on the login form")
+	autoApprove := flag.Bool("auto-approve", false, "skip the login form and sign in -email (or login_hint) right away")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		autoApprove:  *autoApprove,
+		email:        *email,
+		name:         *name,
+		key:          key,
+		codes:        make(map[string]*authCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+
+	log.Printf("Mock OIDC provider for client %q at %s", p.clientID, p.issuer)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,182 @@
+// Package archive streams sets of stored files as ZIP or gzipped tar
+// archives, opening each file only when it is written so nothing is staged
+// on disk or held in memory.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+// Entry is one file of an archive. Name is a slash-separated path inside the
+// archive. Size must be exact for tar archives, whose headers precede the
+// data.
+type Entry struct {
+	Name    string
+	Size    int64
+	Type    string
+	ModTime time.Time
+	Open    func() (io.ReadCloser, error)
+}
+
+// IsValidFormat reports whether format is one Write supports.
+func IsValidFormat(format string) bool {
+	return format == FormatZip || format == FormatTarGz
+}
+
+// ContentType returns the MIME type of an archive format.
+func ContentType(format string) string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Write streams entries to w in the given format. Entry names are cleaned
+// and made unique first.
+func Write(w io.Writer, format string, entries []Entry) error {
+	entries = Dedupe(entries)
+	switch format {
+	case FormatZip:
+		return writeZip(w, entries)
+	case FormatTarGz:
+		return writeTarGz(w, entries)
+	}
+	return fmt.Errorf("unsupported archive format %q", format)
+}
+
+// cleanName turns a stored name into a safe relative path: no leading
+// slashes, no "." or ".." elements and forward slashes only.
+func cleanName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	var parts []string
+	for _, p := range strings.Split(name, "/") {
+		p = strings.TrimSpace(p)
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "file"
+	}
+	return strings.Join(parts, "/")
+}
+
+// Dedupe cleans entry names and renames clashes the way file managers do:
+// the second "report.pdf" becomes "report (1).pdf". Names are compared
+// case-insensitively since archives are often extracted on such file systems.
+func Dedupe(entries []Entry) []Entry {
+	out := make([]Entry, len(entries))
+	used := map[string]bool{}
+	for i, e := range entries {
+		name := cleanName(e.Name)
+		if used[strings.ToLower(name)] {
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			if base == "" || strings.HasSuffix(base, "/") {
+				base, ext = name, ""
+			}
+			for n := 1; ; n++ {
+				candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+				if !used[strings.ToLower(candidate)] {
+					name = candidate
+					break
+				}
+			}
+		}
+		used[strings.ToLower(name)] = true
+		e.Name = name
+		out[i] = e
+	}
+	return out
+}
+
+// compressible reports whether deflating a file of this MIME type is worth
+// the CPU. Media and archives are already compressed.
+func compressible(mimeType string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mimeType, prefix) {
+			return false
+		}
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/x-bzip2", "application/x-xz":
+		return false
+	}
+	return true
+}
+
+// writeZip writes a ZIP archive. archive/zip switches to ZIP64 records by
+// itself for entries and archives beyond 4 GB.
+func writeZip(w io.Writer, entries []Entry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		method := zip.Store
+		if compressible(e.Type) {
+			method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     e.Name,
+			Method:   method,
+			Modified: e.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyEntry(fw, e); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, entries []Entry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.Name,
+			Size:     e.Size,
+			Mode:     0644,
+			ModTime:  e.ModTime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyEntry(tw, e); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func copyEntry(w io.Writer, e Entry) error {
+	rc, err := e.Open()
+	if err != nil {
+		return fmt.Errorf("open %s: %w", e.Name, err)
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("copy %s: %w", e.Name, err)
+	}
+	return nil
+}
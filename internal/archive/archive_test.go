@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"docs/report.pdf", "docs/report.pdf"},
+		{"/etc/passwd", "etc/passwd"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"docs/../../secret", "docs/secret"},
+		{"./a/./b", "a/b"},
+		{`C:\Users\me\notes.txt`, "C:/Users/me/notes.txt"},
+		{`..\..\evil.exe`, "evil.exe"},
+		{"a//b", "a/b"},
+		{" spaced / name.txt ", "spaced/name.txt"},
+		{"", "file"},
+		{"..", "file"},
+		{"/", "file"},
+	}
+	for _, tt := range tests {
+		if got := cleanName(tt.in); got != tt.want {
+			t.Errorf("cleanName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"distinct", []string{"a.txt", "b.txt"}, []string{"a.txt", "b.txt"}},
+		{"clash", []string{"report.pdf", "report.pdf", "report.pdf"}, []string{"report.pdf", "report (1).pdf", "report (2).pdf"}},
+		{"case-insensitive", []string{"Report.PDF", "report.pdf"}, []string{"Report.PDF", "report (1).pdf"}},
+		{"no extension", []string{"README", "README"}, []string{"README", "README (1)"}},
+		{"dotfile", []string{".bashrc", ".bashrc"}, []string{".bashrc", ".bashrc (1)"}},
+		{"dotfile in folder", []string{"home/.profile", "home/.profile"}, []string{"home/.profile", "home/.profile (1)"}},
+		{"double extension", []string{"data.tar.gz", "data.tar.gz"}, []string{"data.tar.gz", "data.tar (1).gz"}},
+		{"same name in other folders", []string{"a/x.txt", "b/x.txt"}, []string{"a/x.txt", "b/x.txt"}},
+		{"rename taken", []string{"x.txt", "x (1).txt", "x.txt"}, []string{"x.txt", "x (1).txt", "x (2).txt"}},
+		{"cleaned clash", []string{"/etc/passwd", "../etc/passwd"}, []string{"etc/passwd", "etc/passwd (1)"}},
+		{"empty names", []string{"", ".."}, []string{"file", "file (1)"}},
+	}
+	for _, tt := range tests {
+		entries := make([]Entry, len(tt.in))
+		for i, name := range tt.in {
+			entries[i] = Entry{Name: name}
+		}
+		out := Dedupe(entries)
+		for i := range out {
+			if out[i].Name != tt.want[i] {
+				t.Errorf("%s: entry %d named %q, want %q", tt.name, i, out[i].Name, tt.want[i])
+			}
+		}
+		if entries[0].Name != tt.in[0] {
+			t.Errorf("%s: Dedupe changed its input", tt.name)
+		}
+	}
+}
+
+func testEntries() []Entry {
+	contents := map[string]string{"a.txt": "first", "A.TXT": "second", "../b.txt": "third"}
+	var entries []Entry
+	for _, name := range []string{"a.txt", "A.TXT", "../b.txt"} {
+		body := contents[name]
+		entries = append(entries, Entry{
+			Name:    name,
+			Size:    int64(len(body)),
+			Type:    "text/plain",
+			ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Open:    func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(body)), nil },
+		})
+	}
+	return entries
+}
+
+var wantFiles = map[string]string{"a.txt": "first", "A (1).TXT": "second", "b.txt": "third"}
+
+func TestWriteZip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatZip, testEntries()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(wantFiles) {
+		t.Fatalf("archive has %d files, want %d", len(zr.File), len(wantFiles))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		if want, ok := wantFiles[f.Name]; !ok || string(body) != want {
+			t.Errorf("%s holds %q, want %q", f.Name, body, want)
+		}
+	}
+}
+
+func TestWriteTarGz(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatTarGz, testEntries()); err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		body, _ := io.ReadAll(tr)
+		if want, ok := wantFiles[h.Name]; !ok || string(body) != want {
+			t.Errorf("%s holds %q, want %q", h.Name, body, want)
+		}
+	}
+	if n != len(wantFiles) {
+		t.Errorf("archive has %d files, want %d", n, len(wantFiles))
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(io.Discard, "rar", testEntries()); err == nil {
+		t.Error("Write accepted an unknown format")
+	}
+}
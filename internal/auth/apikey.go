@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKeyPrefix starts every API key, so they are told apart from access
+// tokens at a glance and by secret scanners.
+const APIKeyPrefix = "fsk_"
+
+// GenerateAPIKey returns a new random API key.
+func GenerateAPIKey() string {
+	return APIKeyPrefix + GenerateRandomString(40)
+}
+
+// scopedHandler marks a route as usable with API keys holding scope.
+type scopedHandler struct {
+	scope string
+	http.Handler
+}
+
+// Scoped opens the route serving h to API keys with the given scope. Routes
+// that aren't wrapped only accept access tokens.
+func Scoped(scope string, h http.HandlerFunc) http.Handler {
+	return scopedHandler{scope: scope, Handler: h}
+}
+
+// routeScope returns the scope the matched route requires of API keys, or ""
+// if the route doesn't take them.
+func routeScope(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	if h, ok := route.GetHandler().(scopedHandler); ok {
+		return h.scope
+	}
+	return ""
+}
+
+// IPAllowed reports whether ip matches one of the allowed addresses or CIDR
+// ranges. An empty list allows every address.
+func IPAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.Contains(a, "/") {
+			if _, network, err := net.ParseCIDR(a); err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(a); allowedIP != nil && allowedIP.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
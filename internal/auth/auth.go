@@ -2,8 +2,10 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,32 +13,78 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Claims are the contents of an access token. StandardClaims.Id is the jti,
+// unique per token; SessionID is the login the token was issued for.
 type Claims struct {
-	UserID int `json:"user_id"`
+	UserID    int    `json:"user_id"`
+	SessionID string `json:"sid"`
 	jwt.StandardClaims
 }
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return HashPasswordCost(password, bcrypt.DefaultCost)
+}
+
+// HashPasswordCost hashes an account password at the configured bcrypt cost.
+func HashPasswordCost(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	return string(bytes), err
 }
 
+// PasswordNeedsRehash reports whether a hash was made at a lower cost than
+// cost, and should be replaced the next time the password is known.
+func PasswordNeedsRehash(hash string, cost int) bool {
+	hashCost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && hashCost < cost
+}
+
 func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
 
-func GenerateJWTToken(userID int, cfg *config.Config) (string, error) {
-	expirationTime := time.Now().Add(cfg.JWTExpiration)
+// dummyPasswordHashes holds, per bcrypt cost, a hash that is compared against
+// when there is no real one, so that takes as long as checking a real
+// password.
+var dummyPasswordHashes sync.Map
+
+func dummyPasswordHash(cost int) string {
+	if hash, ok := dummyPasswordHashes.Load(cost); ok {
+		return hash.(string)
+	}
+	hash, err := HashPasswordCost(GenerateRandomString(32), cost)
+	if err != nil {
+		panic(err)
+	}
+	stored, _ := dummyPasswordHashes.LoadOrStore(cost, hash)
+	return stored.(string)
+}
+
+// VerifyPassword is CheckPasswordHash for logins: for unknown users and users
+// without a password, pass an empty hash, and it spends as long as for a real
+// one made at cost before failing, so response times don't tell which
+// accounts exist.
+func VerifyPassword(password, hash string, cost int) bool {
+	if hash == "" {
+		CheckPasswordHash(password, dummyPasswordHash(cost))
+		return false
+	}
+	return CheckPasswordHash(password, hash)
+}
+
+func GenerateJWTToken(keys *KeySet, userID int, sessionID string, cfg *config.Config) (string, error) {
+	now := time.Now()
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			Id:        GenerateRandomString(32),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(cfg.JWTExpiration).Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JWTSecret))
+	return keys.sign(claims)
 }
 func GenerateRandomString(length int) string {
 	b := make([]byte, length/2+1)
@@ -45,15 +93,18 @@ func GenerateRandomString(length int) string {
 	}
 	return hex.EncodeToString(b)[:length]
 }
-func ValidateJWTToken(tokenString string, cfg *config.Config) (*Claims, error) {
+
+// HashToken returns the hex SHA-256 of a random token, for storing tokens
+// that are looked up rather than checked like passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ValidateJWTToken(tokenString string, keys *KeySet) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(cfg.JWTSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc)
 
 	if err != nil {
 		return nil, err
@@ -62,6 +113,11 @@ func ValidateJWTToken(tokenString string, cfg *config.Config) (*Claims, error) {
 	if !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	// Tokens from before revocation existed can't be revoked, so they aren't
+	// accepted either.
+	if claims.Id == "" || claims.SessionID == "" {
+		return nil, errors.New("token without jti or sid")
+	}
 
 	return claims, nil
 }
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// breachedSearchSpan is the size of file region below which a lookup stops
+// bisecting and reads the lines in it.
+const breachedSearchSpan = 4096
+
+// BreachedPasswords is a list of leaked passwords, as a file of upper-case
+// hex SHA-1 hashes sorted by hash, one per line and optionally followed by
+// ":count". This is the format of the Pwned Passwords download ordered by
+// hash, and the lines a k-anonymity range query answers with are the same
+// minus the first five characters. Lookups bisect the file on disk, so it
+// can be tens of gigabytes.
+type BreachedPasswords struct {
+	f    *os.File
+	size int64
+}
+
+func OpenBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	b := &BreachedPasswords{f: f, size: info.Size()}
+
+	// Catch files in another format before they silently match nothing.
+	if b.size > 0 {
+		_, hash, err := b.lineAt(0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if len(hash) != sha1.Size*2 {
+			f.Close()
+			return nil, fmt.Errorf("%s: expected lines of SHA-1 hashes, got %q", path, hash)
+		}
+	}
+	return b, nil
+}
+
+// Contains reports whether password is on the list.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// The line with target, if any, starts in [lo, hi); lo is a line start.
+	lo, hi := int64(0), b.size
+	for hi-lo > breachedSearchSpan {
+		mid := lo + (hi-lo)/2
+		start, hash, err := b.lineAt(mid)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		switch {
+		case errors.Is(err, io.EOF) || start >= hi:
+			hi = mid
+		case hash == target:
+			return true, nil
+		case hash < target:
+			lo = start
+		default:
+			hi = start
+		}
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(b.f, lo, b.size-lo))
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			switch hash := lineHash(line); {
+			case hash == target:
+				return true, nil
+			case hash > target:
+				return false, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// lineAt returns the offset and hash of the first line starting at or after
+// off. It returns io.EOF if there is none.
+func (b *BreachedPasswords) lineAt(off int64) (int64, string, error) {
+	start := off
+	if off > 0 {
+		start = off - 1
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(b.f, start, b.size-start), 256)
+	if off > 0 {
+		skipped, err := r.ReadString('\n')
+		if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := r.ReadString('\n')
+	if line == "" {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, "", err
+	}
+	return start, lineHash(line), nil
+}
+
+func lineHash(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(line))
+}
+
+func (b *BreachedPasswords) Close() error {
+	return b.f.Close()
+}
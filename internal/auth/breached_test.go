@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeBreachedFixture writes the hashes of passwords, sorted, in the list
+// format: one hash per line, followed by ":count" if counts is set, lines
+// separated by newline, with a final newline if trailing is set.
+func writeBreachedFixture(t *testing.T, passwords []string, counts bool, newline string, trailing bool) string {
+	t.Helper()
+	var lines []string
+	for i, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		line := strings.ToUpper(hex.EncodeToString(sum[:]))
+		if counts {
+			line += fmt.Sprintf(":%d", i+1)
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	data := strings.Join(lines, newline)
+	if trailing {
+		data += newline
+	}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fixturePasswords(n int) []string {
+	passwords := make([]string, n)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("password%d", i)
+	}
+	return passwords
+}
+
+func TestBreachedPasswordsContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		counts   bool
+		newline  string
+		trailing bool
+	}{
+		{"single line", 1, true, "\n", true},
+		{"single line without final newline", 1, true, "\n", false},
+		{"few lines", 3, true, "\n", true},
+		{"without counts", 3, false, "\n", true},
+		{"CRLF", 3, true, "\r\n", true},
+		// Large enough to be bisected rather than read line by line.
+		{"bisected", 2000, true, "\n", true},
+		{"bisected without final newline", 2000, true, "\n", false},
+		{"bisected CRLF", 2000, true, "\r\n", false},
+		{"bisected without counts", 2000, false, "\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwords := fixturePasswords(tt.count)
+			b, err := OpenBreachedPasswords(writeBreachedFixture(t, passwords, tt.counts, tt.newline, tt.trailing))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			// Every listed password, which includes the first and last
+			// lines of the file.
+			for _, p := range passwords {
+				if found, err := b.Contains(p); err != nil || !found {
+					t.Errorf("Contains(%q) = %v, %v, want true", p, found, err)
+				}
+			}
+			for _, p := range []string{"", "password", "not listed", fmt.Sprintf("password%d", tt.count)} {
+				if found, err := b.Contains(p); err != nil || found {
+					t.Errorf("Contains(%q) = %v, %v, want false", p, found, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordsLowerCase(t *testing.T) {
+	sum := sha1.Sum([]byte("hunter2"))
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(sum[:])+":7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if found, err := b.Contains("hunter2"); err != nil || !found {
+		t.Errorf("Contains(hunter2) = %v, %v, want true", found, err)
+	}
+}
+
+func TestBreachedPasswordsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if found, err := b.Contains("password"); err != nil || found {
+		t.Errorf("Contains(password) = %v, %v, want false", found, err)
+	}
+}
+
+func TestOpenBreachedPasswordsRejectsOtherFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	if err := os.WriteFile(path, []byte("123456\npassword\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := OpenBreachedPasswords(path); err == nil {
+		b.Close()
+		t.Fatal("OpenBreachedPasswords accepted a plain password list")
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// ClientIP returns the address of the client that made the request. Behind a
+// trusted proxy that is the last X-Forwarded-For entry, the one the proxy
+// itself appended; earlier entries are whatever the client chose to send.
+func ClientIP(r *http.Request, cfg *config.Config) string {
+	if cfg.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
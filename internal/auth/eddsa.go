@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA implements the EdDSA algorithm of RFC 8037 with Ed25519
+// keys, which jwt-go doesn't provide.
+type signingMethodEdDSA struct{}
+
+var SigningMethodEdDSA jwt.SigningMethod = signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("EdDSA signature is invalid")
+	}
+	return nil
+}
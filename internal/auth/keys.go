@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// insecureJWTSecret is the secret this project shipped as its default.
+const insecureJWTSecret = "very-secret-key"
+
+const (
+	minJWTSecretLength = 32
+	minRSAKeyBits      = 2048
+	hmacKeyID          = "hs256"
+)
+
+// signingKey is one key of a KeySet. Keys without a private half only verify
+// tokens; they are kept after rotation until the tokens they signed expire.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// KeySet holds the keys access tokens are signed and verified with. New
+// tokens are signed with the active key and carry its ID as kid; any key of
+// the set verifies the tokens carrying its kid, so keys can be rotated without
+// signing anyone out.
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// LoadKeySet builds the key set from the configuration. With JWTKeysDir set,
+// every .pem file in it is a key named after the file: RSA (RS256) or Ed25519
+// (EdDSA) private keys in PKCS#1 or PKCS#8 form, or public keys of retired
+// ones. JWTSigningKeyID picks the active key, by default the private key
+// whose name sorts last. Otherwise tokens are signed with HS256 and
+// JWTSecret, which has to be long and not the old default.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*signingKey{}}
+
+	if cfg.JWTKeysDir == "" {
+		switch {
+		case cfg.JWTSecret == "":
+			return nil, errors.New("set JWT_SECRET or JWT_KEYS_DIR")
+		case cfg.JWTSecret == insecureJWTSecret:
+			return nil, errors.New("JWT_SECRET is the well-known default, set a secret of your own")
+		case len(cfg.JWTSecret) < minJWTSecretLength:
+			return nil, fmt.Errorf("JWT_SECRET must be at least %d characters", minJWTSecretLength)
+		}
+		ks.active = &signingKey{
+			id:      hmacKeyID,
+			method:  jwt.SigningMethodHS256,
+			private: []byte(cfg.JWTSecret),
+			public:  []byte(cfg.JWTSecret),
+		}
+		ks.keys[hmacKeyID] = ks.active
+		return ks, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.JWTKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ks.keys[key.id] = key
+		if key.private != nil && cfg.JWTSigningKeyID == "" {
+			ks.active = key
+		}
+	}
+
+	if cfg.JWTSigningKeyID != "" {
+		ks.active = ks.keys[cfg.JWTSigningKeyID]
+		if ks.active == nil {
+			return nil, fmt.Errorf("signing key %q not found in %s", cfg.JWTSigningKeyID, cfg.JWTKeysDir)
+		}
+	}
+	if ks.active == nil || ks.active.private == nil {
+		return nil, fmt.Errorf("no private key to sign with in %s", cfg.JWTKeysDir)
+	}
+	return ks, nil
+}
+
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &signingKey{id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key.private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key.private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.private.(type) {
+	case *rsa.PrivateKey:
+		key.public = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.public = k.Public()
+	case nil:
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", k)
+	}
+
+	switch k := key.public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSAKeyBits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+	return key, nil
+}
+
+// sign signs claims with the active key.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.id
+	return token.SignedString(ks.active.private)
+}
+
+// keyFunc finds the key a token names in its kid header, refusing tokens
+// whose algorithm isn't that key's, so a public key can never be used as an
+// HMAC secret.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := ks.keys[kid]
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set for other services to verify
+// tokens with. HMAC secrets are never published, so with HS256 it is empty.
+func (ks *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch k := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
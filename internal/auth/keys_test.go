@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+var (
+	testRSAKeyOnce sync.Once
+	testRSAKey     *rsa.PrivateKey
+)
+
+// rsaTestKey returns one 2048-bit key for all tests, as generating it is slow.
+func rsaTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testRSAKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRSAKey = key
+	})
+	return testRSAKey
+}
+
+func ed25519TestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+// writePEM writes a PEM block of type typ to dir/name.pem.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePrivateKey(t *testing.T, dir, name string, key interface{}) {
+	t.Helper()
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		writePEM(t, dir, name, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k))
+		return
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, name, "PRIVATE KEY", der)
+}
+
+func writePublicKey(t *testing.T, dir, name string, key interface{}) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, name, "PUBLIC KEY", der)
+}
+
+func loadTestKeySet(t *testing.T, cfg *config.Config) *KeySet {
+	t.Helper()
+	cfg.JWTExpiration = time.Minute
+	ks, err := LoadKeySet(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	return ks
+}
+
+// tokenHeader returns the decoded header of a signed token.
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Header
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	rsaDir, edDir := t.TempDir(), t.TempDir()
+	writePrivateKey(t, rsaDir, "rsa-1", rsaTestKey(t))
+	writePrivateKey(t, edDir, "ed-1", ed25519TestKey(t))
+
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		wantAlg string
+		wantKid string
+	}{
+		{"HS256", &config.Config{JWTSecret: testJWTSecret}, "HS256", hmacKeyID},
+		{"RS256", &config.Config{JWTKeysDir: rsaDir}, "RS256", "rsa-1"},
+		{"EdDSA", &config.Config{JWTKeysDir: edDir}, "EdDSA", "ed-1"},
+	}
+	for _, tt := range tests {
+		ks := loadTestKeySet(t, tt.cfg)
+		token, err := GenerateJWTToken(ks, 42, "session-1", tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: GenerateJWTToken: %v", tt.name, err)
+		}
+		header := tokenHeader(t, token)
+		if header["alg"] != tt.wantAlg || header["kid"] != tt.wantKid {
+			t.Errorf("%s: header alg=%v kid=%v, want %s and %s", tt.name, header["alg"], header["kid"], tt.wantAlg, tt.wantKid)
+		}
+
+		claims, err := ValidateJWTToken(token, ks)
+		if err != nil {
+			t.Fatalf("%s: ValidateJWTToken: %v", tt.name, err)
+		}
+		if claims.UserID != 42 || claims.SessionID != "session-1" {
+			t.Errorf("%s: claims user=%d session=%q", tt.name, claims.UserID, claims.SessionID)
+		}
+
+		// A changed payload must not verify.
+		parts := strings.Split(token, ".")
+		forged := parts[0] + "." + jwt.EncodeSegment([]byte(`{"user_id":1,"sid":"session-1","jti":"x"}`)) + "." + parts[2]
+		if _, err := ValidateJWTToken(forged, ks); err == nil {
+			t.Errorf("%s: a token with a changed payload verified", tt.name)
+		}
+	}
+}
+
+func TestKeySetRejectsUnknownKid(t *testing.T) {
+	dir, otherDir := t.TempDir(), t.TempDir()
+	writePrivateKey(t, dir, "current", ed25519TestKey(t))
+	writePrivateKey(t, otherDir, "stranger", ed25519TestKey(t))
+	ks := loadTestKeySet(t, &config.Config{JWTKeysDir: dir})
+	other := loadTestKeySet(t, &config.Config{JWTKeysDir: otherDir})
+
+	token, err := GenerateJWTToken(other, 1, "s", &config.Config{JWTExpiration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWTToken(token, ks); err == nil {
+		t.Error("a token with an unknown kid verified")
+	}
+
+	// Nor does a token without a kid, even if a key of the set signed it.
+	claims := &Claims{UserID: 1, SessionID: "s", StandardClaims: jwt.StandardClaims{Id: "x"}}
+	unnamed, err := jwt.NewWithClaims(SigningMethodEdDSA, claims).SignedString(ks.active.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWTToken(unnamed, ks); err == nil {
+		t.Error("a token without a kid verified")
+	}
+}
+
+func TestKeySetRejectsMismatchedAlg(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := rsaTestKey(t)
+	writePrivateKey(t, dir, "rsa", rsaKey)
+	writePrivateKey(t, dir, "ed", ed25519TestKey(t))
+	ks := loadTestKeySet(t, &config.Config{JWTKeysDir: dir, JWTSigningKeyID: "rsa"})
+	claims := &Claims{UserID: 1, SessionID: "s", StandardClaims: jwt.StandardClaims{Id: "x"}}
+
+	// HS256 keyed with the published public key, the classic confusion.
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	for _, secret := range [][]byte{pubPEM, pubDER} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = "rsa"
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateJWTToken(signed, ks); err == nil {
+			t.Error("an HS256 token keyed with the RSA public key verified")
+		}
+	}
+
+	// A valid EdDSA signature under the RSA key's kid.
+	token := jwt.NewWithClaims(SigningMethodEdDSA, claims)
+	token.Header["kid"] = "rsa"
+	signed, err := token.SignedString(ks.keys["ed"].private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWTToken(signed, ks); err == nil {
+		t.Error("an EdDSA token naming the RSA key verified")
+	}
+
+	// The library would refuse the key types above anyway; the key set
+	// doesn't even hand out a key for the wrong algorithm.
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodHS256, jwt.SigningMethodRS512, SigningMethodEdDSA} {
+		token := &jwt.Token{Method: method, Header: map[string]interface{}{"kid": "rsa"}}
+		if key, err := ks.keyFunc(token); err == nil {
+			t.Errorf("keyFunc gave %T for a %s token naming the RSA key", key, method.Alg())
+		}
+	}
+
+	// alg none.
+	token = jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	token.Header["kid"] = "rsa"
+	signed, err = token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWTToken(signed, ks); err == nil {
+		t.Error("an unsigned token verified")
+	}
+}
+
+func TestKeySetVerifiesWithRetiredKey(t *testing.T) {
+	oldKey := ed25519TestKey(t)
+	before := t.TempDir()
+	writePrivateKey(t, before, "2024-01", oldKey)
+	oldSet := loadTestKeySet(t, &config.Config{JWTKeysDir: before})
+	cfg := &config.Config{JWTExpiration: time.Minute}
+	oldToken, err := GenerateJWTToken(oldSet, 7, "s", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotation only the public half of the old key is left.
+	after := t.TempDir()
+	writePublicKey(t, after, "2024-01", oldKey.Public())
+	writePrivateKey(t, after, "2024-06", rsaTestKey(t))
+	ks := loadTestKeySet(t, &config.Config{JWTKeysDir: after})
+
+	if claims, err := ValidateJWTToken(oldToken, ks); err != nil || claims.UserID != 7 {
+		t.Errorf("token of the retired key: %v", err)
+	}
+	newToken, err := GenerateJWTToken(ks, 8, "s", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenHeader(t, newToken)["kid"]; kid != "2024-06" {
+		t.Errorf("new tokens signed with %v, want 2024-06", kid)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallDir := t.TempDir()
+	writePrivateKey(t, smallDir, "small", small)
+
+	publicOnly := t.TempDir()
+	writePublicKey(t, publicOnly, "retired", ed25519TestKey(t).Public())
+
+	garbage := t.TempDir()
+	os.WriteFile(filepath.Join(garbage, "key.pem"), []byte("not a key"), 0600)
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{"no secret", &config.Config{}},
+		{"default secret", &config.Config{JWTSecret: insecureJWTSecret}},
+		{"short secret", &config.Config{JWTSecret: "too-short"}},
+		{"small RSA key", &config.Config{JWTKeysDir: smallDir}},
+		{"only public keys", &config.Config{JWTKeysDir: publicOnly}},
+		{"public key chosen", &config.Config{JWTKeysDir: publicOnly, JWTSigningKeyID: "retired"}},
+		{"unknown key chosen", &config.Config{JWTKeysDir: smallDir, JWTSigningKeyID: "missing"}},
+		{"not PEM", &config.Config{JWTKeysDir: garbage}},
+		{"empty directory", &config.Config{JWTKeysDir: t.TempDir()}},
+	}
+	for _, tt := range tests {
+		if _, err := LoadKeySet(tt.cfg); err == nil {
+			t.Errorf("%s: LoadKeySet succeeded", tt.name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, edKey := rsaTestKey(t), ed25519TestKey(t)
+	writePrivateKey(t, dir, "a-rsa", rsaKey)
+	writePublicKey(t, dir, "b-ed", edKey.Public())
+	ks := loadTestKeySet(t, &config.Config{JWTKeysDir: dir, JWTSigningKeyID: "a-rsa"})
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2", len(jwks.Keys))
+	}
+
+	r := jwks.Keys[0]
+	if r.Kty != "RSA" || r.Kid != "a-rsa" || r.Use != "sig" || r.Alg != "RS256" || r.Crv != "" || r.X != "" {
+		t.Errorf("RSA JWK = %+v", r)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(r.N)
+	if err != nil || new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 {
+		t.Errorf("RSA JWK n doesn't match the key: %v", err)
+	}
+	if r.E != "AQAB" {
+		t.Errorf("RSA JWK e = %q, want AQAB", r.E)
+	}
+
+	e := jwks.Keys[1]
+	if e.Kty != "OKP" || e.Crv != "Ed25519" || e.Kid != "b-ed" || e.Use != "sig" || e.Alg != "EdDSA" || e.N != "" || e.E != "" {
+		t.Errorf("Ed25519 JWK = %+v", e)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(e.X)
+	if err != nil || string(x) != string(edKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("Ed25519 JWK x doesn't match the key: %v", err)
+	}
+
+	hmac := loadTestKeySet(t, &config.Config{JWTSecret: testJWTSecret})
+	if keys := hmac.JWKS().Keys; keys == nil || len(keys) != 0 {
+		t.Errorf("HS256 JWKS = %v, want an empty list", keys)
+	}
+}
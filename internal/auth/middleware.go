@@ -2,13 +2,24 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
 )
 
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// AuthMiddleware accepts requests with a valid, unrevoked access token or API
+// key and puts the user ID in the request context, along with the session ID
+// and token claims for access tokens or the key ID for API keys. Revocation
+// can't be checked without Redis, so requests fail until it is back rather
+// than letting revoked tokens through.
+func AuthMiddleware(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *KeySet) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -24,14 +35,75 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 			}
 
 			tokenString := tokenParts[1]
-			claims, err := ValidateJWTToken(tokenString, cfg)
+			if strings.HasPrefix(tokenString, APIKeyPrefix) {
+				serveWithAPIKey(w, r, db, cfg, tokenString, next)
+				return
+			}
+
+			claims, err := ValidateJWTToken(tokenString, keys)
 			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
+			revoked, err := IsRevoked(r.Context(), rdb, claims)
+			if err != nil {
+				log.Printf("Failed to check token revocation: %v", err)
+				http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+			ctx = context.WithValue(ctx, "sessionID", claims.SessionID)
+			ctx = context.WithValue(ctx, "claims", claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// serveWithAPIKey authenticates the request with an API key. Keys only work on
+// routes marked with Scoped, and only if they hold the route's scope.
+func serveWithAPIKey(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, key string, next http.Handler) {
+	apiKey, err := models.GetAPIKeyByHash(db, HashToken(key))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up API key: %v", err)
+			http.Error(w, "Failed to check API key", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if !apiKey.IsActive(time.Now()) {
+		http.Error(w, "API key has expired or been revoked", http.StatusUnauthorized)
+		return
+	}
+
+	ip := ClientIP(r, cfg)
+	if !IPAllowed(ip, apiKey.AllowedIPs) {
+		http.Error(w, "API key is not allowed from this address", http.StatusForbidden)
+		return
+	}
+
+	scope := routeScope(r)
+	if scope == "" {
+		http.Error(w, "This endpoint can't be used with an API key", http.StatusForbidden)
+		return
+	}
+	if !apiKey.HasScope(scope) {
+		http.Error(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	if err := models.TouchAPIKey(db, apiKey.ID, ip); err != nil {
+		log.Printf("Failed to record use of API key %d: %v", apiKey.ID, err)
+	}
+
+	ctx := context.WithValue(r.Context(), "userID", apiKey.UserID)
+	ctx = context.WithValue(ctx, "apiKeyID", apiKey.ID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// oidcKeyRefetchInterval limits how often an unknown kid makes the provider
+// fetch its keys again, so tokens with made-up kids can't make us hammer it.
+const oidcKeyRefetchInterval = time.Minute
+
+// idTokenLeeway allows for clock differences with the identity provider.
+const idTokenLeeway = time.Minute
+
+// OIDCProvider signs users in with an OpenID Connect identity provider using
+// the authorization code flow with PKCE. The provider's endpoints are looked
+// up through discovery on first use, so the server starts even while the
+// provider is unreachable.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the claims of an ID token the login relies on.
+type IDTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// audience is the aud claim, which may be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid checks the token's lifetime; the rest is checked by Exchange, which
+// knows what to expect.
+func (c *IDTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(idTokenLeeway)) {
+		return errors.New("ID token has expired")
+	}
+	if c.IssuedAt != 0 && now.Add(idTokenLeeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("ID token is issued in the future")
+	}
+	return nil
+}
+
+// NewOIDCProvider returns the configured identity provider, or nil if single
+// sign-on isn't enabled.
+func NewOIDCProvider(cfg *config.Config) *OIDCProvider {
+	if cfg.OIDCIssuerURL == "" {
+		return nil
+	}
+	scopes := cfg.OIDCScopes
+	hasOpenID := false
+	for _, s := range scopes {
+		hasOpenID = hasOpenID || s == "openid"
+	}
+	if !hasOpenID {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return &OIDCProvider{
+		issuer:       cfg.OIDCIssuerURL,
+		clientID:     cfg.OIDCClientID,
+		clientSecret: cfg.OIDCClientSecret,
+		redirectURL:  cfg.OIDCRedirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer returns the provider's issuer identifier, which together with the
+// subject identifies a user.
+func (p *OIDCProvider) Issuer() string {
+	return p.issuer
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the provider's metadata, fetching it the first time.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var md oidcMetadata
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery: issuer %q doesn't match %q", md.Issuer, p.issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("discovery: provider metadata lacks required endpoints")
+	}
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// NewPKCEVerifier returns a random PKCE code verifier (RFC 7636).
+func NewPKCEVerifier() string {
+	return GenerateRandomString(64)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL to send the browser to. state and
+// nonce tie the eventual callback and ID token to this login attempt.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the
+// validated ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDTokenClaims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("token response: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("token request: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("token response has no ID token")
+	}
+	return p.verifyIDToken(ctx, md, body.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, md *oidcMetadata, idToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, md, kid)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+		case ed25519.PublicKey:
+			if token.Method != SigningMethodEdDSA {
+				return nil, errors.New("unexpected signing method")
+			}
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("ID token issuer %q doesn't match", claims.Issuer)
+	}
+	if !claims.Audience.contains(p.clientID) {
+		return nil, errors.New("ID token isn't meant for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID {
+		return nil, errors.New("ID token is authorized for another party")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// key returns the provider's signing key with the given kid, fetching the
+// provider's keys again if it is unknown, since that is how providers
+// announce a rotation.
+func (p *OIDCProvider) key(ctx context.Context, md *oidcMetadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeyRefetchInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var jwks JWKS
+	if err := p.getJSON(ctx, md.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := parseJWK(jwk); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// parseJWK decodes an RSA or Ed25519 public key.
+func parseJWK(jwk JWK) (interface{}, error) {
+	switch {
+	case jwk.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
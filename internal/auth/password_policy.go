@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// maxPasswordBytes is as much of a password as bcrypt looks at.
+const maxPasswordBytes = 72
+
+var (
+	ErrPasswordContainsEmail = errors.New("Password must not contain your email address")
+	ErrPasswordBreached      = errors.New("This password has appeared in a data breach, please choose another")
+)
+
+// PasswordPolicy decides which passwords users may choose for their
+// accounts.
+type PasswordPolicy struct {
+	minLength int
+	breached  *BreachedPasswords
+}
+
+// NewPasswordPolicy sets up the policy configured in cfg, opening the
+// breached password list if there is one.
+func NewPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{minLength: cfg.PasswordMinLength}
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := OpenBreachedPasswords(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		p.breached = breached
+	}
+	return p, nil
+}
+
+// Check returns why password can't be the password of the account with
+// email, or nil if it can. The message of the error is meant for the user.
+func (p *PasswordPolicy) Check(password, email string) error {
+	if n := utf8.RuneCountInString(password); n < p.minLength {
+		return fmt.Errorf("Password must be at least %d characters long", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes long", maxPasswordBytes)
+	}
+
+	lower := strings.ToLower(password)
+	email = strings.ToLower(email)
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	if email != "" && strings.Contains(lower, email) || utf8.RuneCountInString(local) >= 3 && strings.Contains(lower, local) {
+		return ErrPasswordContainsEmail
+	}
+
+	if p.breached != nil {
+		// A list that can't be read shouldn't stop people from signing up.
+		found, err := p.breached.Contains(password)
+		if err != nil {
+			log.Printf("Failed to look up breached passwords: %v", err)
+		} else if found {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
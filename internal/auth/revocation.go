@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Access tokens can't be recalled once issued, so revoked ones are listed in
+// Redis until they would have expired anyway: single tokens by jti, and whole
+// sessions by sid.
+
+func revokedTokenKey(tokenID string) string {
+	return "revoked_token:" + tokenID
+}
+
+func revokedSessionKey(sessionID string) string {
+	return "revoked_session:" + sessionID
+}
+
+// RevokeToken denies an access token for the rest of its lifetime.
+func RevokeToken(ctx context.Context, rdb *redis.Client, claims *Claims) error {
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if ttl <= 0 {
+		return nil
+	}
+	return rdb.Set(ctx, revokedTokenKey(claims.Id), 1, ttl).Err()
+}
+
+// RevokeSessionTokens denies every access token of a session. ttl must be at
+// least the access token lifetime.
+func RevokeSessionTokens(ctx context.Context, rdb *redis.Client, sessionID string, ttl time.Duration) error {
+	return rdb.Set(ctx, revokedSessionKey(sessionID), 1, ttl).Err()
+}
+
+// IsRevoked reports whether the token or its session has been revoked.
+func IsRevoked(ctx context.Context, rdb *redis.Client, claims *Claims) (bool, error) {
+	n, err := rdb.Exists(ctx, revokedTokenKey(claims.Id), revokedSessionKey(claims.SessionID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// supports; some ignore otpauth parameters that differ from them.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many periods a code may be early or late, for clock
+	// drift and slow typing.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random 160-bit secret, base32 encoded as
+// authenticator apps expect.
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps import, usually
+// by scanning it as a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	// Some apps show a + in the issuer literally.
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// hotp computes the HOTP value (RFC 4226) of key for counter.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPCode returns the code for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, t.Unix()/totpPeriod), nil
+}
+
+// ValidateTOTP checks code against secret at time t. It returns the time step
+// the code belongs to, which callers store so that a code can't be used
+// twice.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryCodeBytes is the entropy of a recovery code. At 80 bits the codes
+// are stored like other random tokens, as a plain hash that can't be
+// reversed by trying every code.
+const recoveryCodeBytes = 10
+
+// GenerateRecoveryCodes returns n single-use codes for when the
+// authenticator is lost, formatted as xxxx-xxxx-xxxx-xxxx in lower-case
+// base32.
+func GenerateRecoveryCodes(n int) []string {
+	codes := make([]string, n)
+	b := make([]byte, recoveryCodeBytes)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+	}
+	return codes
+}
+
+// HashRecoveryCode hashes a recovery code for storage, ignoring the case and
+// separators people add or drop when typing it. Codes issued before they had
+// 80 bits hash the same way and keep working until replaced.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
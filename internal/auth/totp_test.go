@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; a 6-digit code is their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if want := tt.want[len(tt.want)-totpDigits:]; got != want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code, err := TOTPCode(rfc6238Secret, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := issued.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		code   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"same step", code, 0, true},
+		{"one step late", code, totpPeriod * time.Second, true},
+		{"one step early", code, -totpPeriod * time.Second, true},
+		{"two steps late", code, 2 * totpPeriod * time.Second, false},
+		{"two steps early", code, -2 * totpPeriod * time.Second, false},
+		{"spaces", code[:3] + " " + code[3:] + " ", 0, true},
+		{"wrong code", "000000", 0, false},
+		{"too short", code[:totpDigits-1], 0, false},
+		{"too long", code + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ValidateTOTP(rfc6238Secret, tt.code, issued.Add(tt.offset))
+		if ok != tt.ok {
+			t.Errorf("%s: ValidateTOTP ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		// Replay protection relies on a code reporting the step it was
+		// issued for wherever in the window it is entered.
+		if ok && got != step {
+			t.Errorf("%s: ValidateTOTP step = %d, want %d", tt.name, got, step)
+		}
+	}
+}
+
+func TestValidateTOTPBadSecret(t *testing.T) {
+	if _, ok := ValidateTOTP("not base32!", "123456", time.Now()); ok {
+		t.Error("ValidateTOTP accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	codes := GenerateRecoveryCodes(10)
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if !format.MatchString(c) {
+			t.Errorf("code %q doesn't look like xxxx-xxxx-xxxx-xxxx", c)
+		}
+		if seen[c] {
+			t.Errorf("code %q repeated", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	code := GenerateRecoveryCodes(1)[0]
+	want := HashRecoveryCode(code)
+	for _, typed := range []string{
+		strings.ToUpper(code),
+		strings.ReplaceAll(code, "-", ""),
+		strings.ReplaceAll(code, "-", " "),
+	} {
+		if got := HashRecoveryCode(typed); got != want {
+			t.Errorf("HashRecoveryCode(%q) differs from HashRecoveryCode(%q)", typed, code)
+		}
+	}
+	if HashRecoveryCode(GenerateRecoveryCodes(1)[0]) == want {
+		t.Error("two recovery codes hash the same")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,7 +12,10 @@ type Config struct {
 	ServerPort      string
 	ServerBaseURL   string
 	JWTSecret       string
+	JWTKeysDir      string
+	JWTSigningKeyID string
 	JWTExpiration   time.Duration
+	RefreshTokenTTL time.Duration
 	DBHost          string
 	DBPort          string
 	DBUser          string
@@ -25,14 +29,86 @@ type Config struct {
 	S3Bucket        string
 	S3Region        string
 	LocalStorageDir string
+
+	// Share link expiry policy. ShareMaxExpiry of zero means no maximum;
+	// never-expiring links additionally require ShareAllowNeverExpire.
+	ShareDefaultExpiry    time.Duration
+	ShareMaxExpiry        time.Duration
+	ShareAllowNeverExpire bool
+
+	// ShareAccessLogRetention is how long share access log entries, which
+	// hold client addresses and user agents, are kept. Zero keeps them.
+	ShareAccessLogRetention time.Duration
+
+	// TrustProxyHeaders takes client addresses from X-Forwarded-For and
+	// X-Real-IP. Only enable it behind a proxy that sets them.
+	TrustProxyHeaders bool
+
+	// ArchiveMaxSize caps the total size in bytes of the files in one bulk
+	// download archive. Zero means no limit.
+	ArchiveMaxSize int64
+
+	// DropMaxUploadSize caps the size in bytes of one upload through a drop
+	// link, and so the max_file_size such links can be given.
+	DropMaxUploadSize int64
+
+	// OpenID Connect single sign-on, enabled by setting OIDCIssuerURL.
+	// PasswordLoginEnabled false leaves single sign-on as the only way in.
+	OIDCIssuerURL        string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCScopes           []string
+	PasswordLoginEnabled bool
+
+	// Require2FA makes every login use TOTP, enrolling users who haven't set
+	// it up when they next log in. OIDCTrustProvider2FA exempts single
+	// sign-on logins from the enrollment, for providers that enforce their
+	// own second factor. TOTPIssuer names the service in authenticator apps.
+	Require2FA           bool
+	OIDCTrustProvider2FA bool
+	TOTPIssuer           string
+
+	// Outgoing mail. MailDriver is "log" to only log messages, or "smtp".
+	MailDriver   string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// RequireEmailVerification refuses password logins until the email
+	// address is verified.
+	RequireEmailVerification bool
+	EmailVerificationTTL     time.Duration
+	PasswordResetTTL         time.Duration
+
+	// Failed password logins per email address and per client address.
+	// After a few, each further one makes the next attempt wait longer;
+	// at the maximum, logins are locked for LoginLockoutDuration.
+	LoginMaxAttempts     int
+	LoginIPMaxAttempts   int
+	LoginLockoutDuration time.Duration
+
+	// Password policy for accounts. BreachedPasswordsFile lists the SHA-1
+	// hashes of known leaked passwords, which are refused. Password hashes
+	// with a lower bcrypt cost than BcryptCost are upgraded at login.
+	PasswordMinLength     int
+	BreachedPasswordsFile string
+	BcryptCost            int
 }
 
 func LoadConfig() *Config {
-	jwtExp, err := time.ParseDuration(getEnv("JWT_EXPIRATION", "24h"))
+	jwtExp, err := time.ParseDuration(getEnv("JWT_EXPIRATION", "15m"))
 	if err != nil {
 		log.Fatalf("Failed to parse JWT expiration: %v", err)
 	}
 
+	refreshTokenTTL, err := time.ParseDuration(getEnv("REFRESH_TOKEN_EXPIRATION", "720h"))
+	if err != nil {
+		log.Fatalf("Failed to parse refresh token expiration: %v", err)
+	}
+
 	redisDB, err := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	if err != nil {
 		log.Fatalf("Failed to parse Redis DB: %v", err)
@@ -43,11 +119,135 @@ func LoadConfig() *Config {
 		log.Fatalf("Failed to parse S3 enabled flag: %v", err)
 	}
 
+	shareDefaultExpiry, err := time.ParseDuration(getEnv("SHARE_DEFAULT_EXPIRY", "24h"))
+	if err != nil {
+		log.Fatalf("Failed to parse share default expiry: %v", err)
+	}
+
+	shareMaxExpiry, err := time.ParseDuration(getEnv("SHARE_MAX_EXPIRY", "0"))
+	if err != nil {
+		log.Fatalf("Failed to parse share max expiry: %v", err)
+	}
+	if shareMaxExpiry > 0 && shareDefaultExpiry > shareMaxExpiry {
+		log.Fatalf("SHARE_DEFAULT_EXPIRY (%s) exceeds SHARE_MAX_EXPIRY (%s)", shareDefaultExpiry, shareMaxExpiry)
+	}
+
+	shareAllowNeverExpire, err := strconv.ParseBool(getEnv("SHARE_ALLOW_NEVER_EXPIRE", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse share never-expire flag: %v", err)
+	}
+
+	shareAccessLogRetention, err := time.ParseDuration(getEnv("SHARE_ACCESS_LOG_RETENTION", "2160h"))
+	if err != nil {
+		log.Fatalf("Failed to parse share access log retention: %v", err)
+	}
+	if shareAccessLogRetention < 0 {
+		log.Fatalf("SHARE_ACCESS_LOG_RETENTION must not be negative")
+	}
+
+	trustProxyHeaders, err := strconv.ParseBool(getEnv("TRUST_PROXY_HEADERS", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse trust proxy headers flag: %v", err)
+	}
+
+	archiveMaxSize, err := strconv.ParseInt(getEnv("ARCHIVE_MAX_SIZE", "10737418240"), 10, 64)
+	if err != nil {
+		log.Fatalf("Failed to parse archive max size: %v", err)
+	}
+	if archiveMaxSize < 0 {
+		log.Fatalf("ARCHIVE_MAX_SIZE must not be negative")
+	}
+
+	dropMaxUploadSize, err := strconv.ParseInt(getEnv("DROP_MAX_UPLOAD_SIZE", "1073741824"), 10, 64)
+	if err != nil {
+		log.Fatalf("Failed to parse drop max upload size: %v", err)
+	}
+	if dropMaxUploadSize < 1 {
+		log.Fatalf("DROP_MAX_UPLOAD_SIZE must be at least 1")
+	}
+
+	serverBaseURL := getEnv("SERVER_BASE_URL", "http://localhost:8080")
+
+	oidcIssuerURL := strings.TrimSuffix(getEnv("OIDC_ISSUER_URL", ""), "/")
+	oidcClientID := getEnv("OIDC_CLIENT_ID", "")
+	if oidcIssuerURL != "" && oidcClientID == "" {
+		log.Fatalf("OIDC_CLIENT_ID is required with OIDC_ISSUER_URL")
+	}
+
+	passwordLoginEnabled, err := strconv.ParseBool(getEnv("PASSWORD_LOGIN_ENABLED", "true"))
+	if err != nil {
+		log.Fatalf("Failed to parse password login flag: %v", err)
+	}
+	if !passwordLoginEnabled && oidcIssuerURL == "" {
+		log.Fatalf("PASSWORD_LOGIN_ENABLED=false requires OIDC_ISSUER_URL, or nobody could log in")
+	}
+
+	require2FA, err := strconv.ParseBool(getEnv("REQUIRE_2FA", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse require 2FA flag: %v", err)
+	}
+	oidcTrustProvider2FA, err := strconv.ParseBool(getEnv("OIDC_TRUST_PROVIDER_2FA", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse OIDC trust provider 2FA flag: %v", err)
+	}
+
+	requireEmailVerification, err := strconv.ParseBool(getEnv("REQUIRE_EMAIL_VERIFICATION", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse require email verification flag: %v", err)
+	}
+
+	emailVerificationTTL, err := time.ParseDuration(getEnv("EMAIL_VERIFICATION_EXPIRATION", "48h"))
+	if err != nil {
+		log.Fatalf("Failed to parse email verification expiration: %v", err)
+	}
+
+	passwordResetTTL, err := time.ParseDuration(getEnv("PASSWORD_RESET_EXPIRATION", "1h"))
+	if err != nil {
+		log.Fatalf("Failed to parse password reset expiration: %v", err)
+	}
+
+	loginMaxAttempts, err := strconv.Atoi(getEnv("LOGIN_MAX_ATTEMPTS", "10"))
+	if err != nil {
+		log.Fatalf("Failed to parse login max attempts: %v", err)
+	}
+
+	loginIPMaxAttempts, err := strconv.Atoi(getEnv("LOGIN_IP_MAX_ATTEMPTS", "100"))
+	if err != nil {
+		log.Fatalf("Failed to parse login IP max attempts: %v", err)
+	}
+	if loginMaxAttempts < 1 || loginIPMaxAttempts < 1 {
+		log.Fatalf("LOGIN_MAX_ATTEMPTS and LOGIN_IP_MAX_ATTEMPTS must be at least 1")
+	}
+
+	loginLockoutDuration, err := time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	if err != nil {
+		log.Fatalf("Failed to parse login lockout duration: %v", err)
+	}
+
+	passwordMinLength, err := strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "8"))
+	if err != nil {
+		log.Fatalf("Failed to parse password min length: %v", err)
+	}
+	if passwordMinLength < 1 {
+		log.Fatalf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+
+	bcryptCost, err := strconv.Atoi(getEnv("BCRYPT_COST", "12"))
+	if err != nil {
+		log.Fatalf("Failed to parse bcrypt cost: %v", err)
+	}
+	if bcryptCost < 10 || bcryptCost > 31 {
+		log.Fatalf("BCRYPT_COST must be between 10 and 31")
+	}
+
 	return &Config{
 		ServerPort:      getEnv("SERVER_PORT", "8080"),
-		ServerBaseURL:   getEnv("SERVER_BASE_URL", "http://localhost:8080"),
-		JWTSecret:       getEnv("JWT_SECRET", "very-secret-key"),
+		ServerBaseURL:   serverBaseURL,
+		JWTSecret:       getEnv("JWT_SECRET", ""),
+		JWTKeysDir:      getEnv("JWT_KEYS_DIR", ""),
+		JWTSigningKeyID: getEnv("JWT_SIGNING_KEY_ID", ""),
 		JWTExpiration:   jwtExp,
+		RefreshTokenTTL: refreshTokenTTL,
 		DBHost:          getEnv("DB_HOST", "localhost"),
 		DBPort:          getEnv("DB_PORT", "5432"),
 		DBUser:          getEnv("DB_USER", "fileshare_user"),
@@ -61,6 +261,48 @@ func LoadConfig() *Config {
 		S3Bucket:        getEnv("S3_BUCKET", ""),
 		S3Region:        getEnv("S3_REGION", ""),
 		LocalStorageDir: getEnv("LOCAL_STORAGE_DIR", "./uploads"),
+
+		ShareDefaultExpiry:    shareDefaultExpiry,
+		ShareMaxExpiry:        shareMaxExpiry,
+		ShareAllowNeverExpire: shareAllowNeverExpire,
+
+		ShareAccessLogRetention: shareAccessLogRetention,
+
+		TrustProxyHeaders: trustProxyHeaders,
+
+		ArchiveMaxSize: archiveMaxSize,
+
+		DropMaxUploadSize: dropMaxUploadSize,
+
+		OIDCIssuerURL:        oidcIssuerURL,
+		OIDCClientID:         oidcClientID,
+		OIDCClientSecret:     getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:      getEnv("OIDC_REDIRECT_URL", strings.TrimSuffix(serverBaseURL, "/")+"/auth/oidc/callback"),
+		OIDCScopes:           strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+		PasswordLoginEnabled: passwordLoginEnabled,
+
+		Require2FA:           require2FA,
+		OIDCTrustProvider2FA: oidcTrustProvider2FA,
+		TOTPIssuer:           getEnv("TOTP_ISSUER", "Go File Share"),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "Go File Share <noreply@localhost>"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+
+		RequireEmailVerification: requireEmailVerification,
+		EmailVerificationTTL:     emailVerificationTTL,
+		PasswordResetTTL:         passwordResetTTL,
+
+		LoginMaxAttempts:     loginMaxAttempts,
+		LoginIPMaxAttempts:   loginIPMaxAttempts,
+		LoginLockoutDuration: loginLockoutDuration,
+
+		PasswordMinLength:     passwordMinLength,
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+		BcryptCost:            bcryptCost,
 	}
 }
 
@@ -0,0 +1,112 @@
+// Package extract pulls searchable plain text out of uploaded documents.
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxInputSize bounds how much of a file is read. Office documents are ZIP
+	// archives and have to be held in memory to be opened.
+	MaxInputSize = 32 << 20
+	// MaxTextSize bounds the extracted text, keeping the tsvector built from it
+	// well below PostgreSQL's 1MB limit.
+	MaxTextSize = 256 << 10
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file type")
+	ErrTooLarge    = errors.New("file too large for text extraction")
+)
+
+type kind int
+
+const (
+	kindUnsupported kind = iota
+	kindPlain
+	kindPDF
+	kindOffice
+)
+
+var plainExtensions = map[string]bool{
+	".txt": true, ".text": true, ".md": true, ".markdown": true,
+	".csv": true, ".log": true, ".json": true, ".xml": true,
+}
+
+var officeTypes = map[string]bool{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+func detect(name, mimeType string) kind {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	ext := strings.ToLower(filepath.Ext(name))
+
+	switch {
+	case mimeType == "application/pdf" || ext == ".pdf":
+		return kindPDF
+	case officeTypes[mimeType] || ext == ".docx" || ext == ".xlsx" || ext == ".pptx":
+		return kindOffice
+	case strings.HasPrefix(mimeType, "text/") || plainExtensions[ext]:
+		return kindPlain
+	}
+	return kindUnsupported
+}
+
+// Supported reports whether Text can extract anything from a file with the
+// given name and MIME type.
+func Supported(name, mimeType string) bool {
+	return detect(name, mimeType) != kindUnsupported
+}
+
+// Text extracts plain text from r. The format is chosen from the MIME type,
+// falling back to the file extension since browsers often upload documents as
+// application/octet-stream. The result is valid UTF-8 without NUL bytes and at
+// most MaxTextSize bytes long.
+func Text(r io.Reader, name, mimeType string) (string, error) {
+	k := detect(name, mimeType)
+	if k == kindUnsupported {
+		return "", ErrUnsupported
+	}
+
+	if k == kindPlain {
+		data, err := io.ReadAll(io.LimitReader(r, MaxTextSize))
+		if err != nil {
+			return "", err
+		}
+		return clean(data), nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxInputSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxInputSize {
+		return "", ErrTooLarge
+	}
+
+	if k == kindPDF {
+		return clean(pdfText(data)), nil
+	}
+	text, err := officeText(data)
+	if err != nil {
+		return "", err
+	}
+	return clean(text), nil
+}
+
+// clean makes extracted text safe to store in a PostgreSQL text column and
+// truncates it to MaxTextSize. A rune cut in half by the truncation is dropped
+// along with any other invalid UTF-8.
+func clean(data []byte) string {
+	data = bytes.ReplaceAll(data, []byte{0}, nil)
+	if len(data) > MaxTextSize {
+		data = data[:MaxTextSize]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(data), ""))
+}
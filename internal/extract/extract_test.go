@@ -0,0 +1,264 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadPDFString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		n    int
+	}{
+		{`(hello) Tj`, "hello", 7},
+		{`()`, "", 2},
+		{`(a (nested) string)`, "a (nested) string", 19},
+		{`(a\(b\)c)`, "a(b)c", 9},
+		{`(back\\slash)`, `back\slash`, 13},
+		{`(line\nbreak\ttab\rret)`, "line\nbreak\ttab\rret", 23},
+		{`(\b\fgone)`, "gone", 10},
+		{`(\101\102C)`, "ABC", 11},
+		{`(\0533)`, "+3", 7},
+		{`(\7)`, "\a", 4},
+		{`(\53)`, "+", 5},
+		{`(\q)`, "q", 4},
+		{"(split \\\nline)", "split line", 14},
+		{"(split \\\r\nline)", "split line", 15},
+		{`(unterminated`, "unterminated", 13},
+		{`(trailing\`, "trailing", 10},
+	}
+	for _, tt := range tests {
+		got, n := readPDFString([]byte(tt.in))
+		if string(got) != tt.want || n != tt.n {
+			t.Errorf("readPDFString(%q) = %q, %d; want %q, %d", tt.in, got, n, tt.want, tt.n)
+		}
+	}
+}
+
+func TestDecodePDFString(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("plain"), "plain"},
+		{[]byte{'c', 'a', 'f', 0xE9}, "café"},
+		{[]byte{0xFE, 0xFF, 0x00, 'H', 0x00, 'i', 0x20, 0xAC}, "Hi€"},
+		{[]byte{0xFE, 0xFF, 0xD8, 0x3D, 0xDE, 0x00}, "😀"},
+		{[]byte{0xFE, 0xFF, 0x00}, ""},
+	}
+	for _, tt := range tests {
+		if got := string(decodePDFString(tt.in)); got != tt.want {
+			t.Errorf("decodePDFString(% x) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// pdfFixture wraps content streams in just enough PDF for pdfText, deflating
+// those marked compressed.
+func pdfFixture(t *testing.T, streams []string, compressed []bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		data := []byte(s)
+		if compressed[i] {
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(data)
+			zw.Close()
+			data = z.Bytes()
+		}
+		buf.WriteString("1 0 obj\n<< /Length 0 >>\nstream\r\n")
+		buf.Write(data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+	buf.WriteString("%%EOF\n")
+	return buf.Bytes()
+}
+
+func TestPDFText(t *testing.T) {
+	data := pdfFixture(t, []string{
+		"BT /F1 12 Tf 72 712 Td (Quarterly) Tj (report) Tj ET",
+		"BT [(Hel) -20 (lo)] TJ % a comment (ignored) Tj\n(World) ' ET",
+		"q 1 0 0 1 0 0 cm (not text) Q",
+		"BT 0 0 Td (caf\\351 \\(draft\\)) Tj ET",
+	}, []bool{false, true, true, false})
+
+	got := string(pdfText(data))
+	for _, want := range []string{"Quarterly report", "Hello", "World", "café (draft)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("pdfText = %q, missing %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"ignored", "not text", "Tf", "72"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("pdfText = %q, shouldn't contain %q", got, unwanted)
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"ppt/slides/slide2.xml", "ppt/slides/slide10.xml", true},
+		{"ppt/slides/slide10.xml", "ppt/slides/slide2.xml", false},
+		{"ppt/slides/slide1.xml", "ppt/slides/slide1.xml", false},
+		{"word/document.xml", "word/footer1.xml", true},
+		{"word/footer1.xml", "word/header1.xml", true},
+		{"word/header", "word/header1.xml", true},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestXMLText(t *testing.T) {
+	in := `<w:document><w:body>
+		<w:p><w:r><w:t>Terms &amp; conditions</w:t></w:r></w:p>
+		<w:p><w:r><w:t>a</w:t><w:tab/><w:t>b</w:t><w:br/><w:t>c</w:t></w:r></w:p>
+		<w:p><w:r><w:instrText>not shown</w:instrText></w:r></w:p>
+	</w:body></w:document>`
+	var out bytes.Buffer
+	if err := xmlText(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Terms & conditions\na\tb\nc\n\n"; out.String() != want {
+		t.Errorf("xmlText = %q, want %q", out.String(), want)
+	}
+
+	if err := xmlText(strings.NewReader("<w:t>unclosed"), &out); err == nil {
+		t.Error("xmlText accepted malformed XML")
+	}
+}
+
+// officeFixture builds an Office document from its parts.
+func officeFixture(t *testing.T, parts map[string]string, order []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range order {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(parts[name]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func slideXML(text string) string {
+	return `<p:sld><p:cSld><p:spTree><p:sp><p:txBody><a:p><a:r><a:t>` + text + `</a:t></a:r></a:p></p:txBody></p:sp></p:spTree></p:cSld></p:sld>`
+}
+
+func TestOfficeText(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[string]string
+		order []string
+		want  string
+	}{
+		{
+			name: "slides in natural order",
+			parts: map[string]string{
+				"[Content_Types].xml":         `<Types/>`,
+				"ppt/slides/slide10.xml":      slideXML("ten"),
+				"ppt/slides/slide2.xml":       slideXML("two"),
+				"ppt/slides/slide1.xml":       slideXML("one"),
+				"ppt/slides/_rels/slide1.xml": `<Relationships/>`,
+				"ppt/notesSlides/notes1.xml":  slideXML("speaker notes"),
+			},
+			order: []string{"[Content_Types].xml", "ppt/slides/slide10.xml", "ppt/slides/slide2.xml",
+				"ppt/slides/slide1.xml", "ppt/slides/_rels/slide1.xml", "ppt/notesSlides/notes1.xml"},
+			want: "one\ntwo\nten\n",
+		},
+		{
+			name: "shared strings of a sheet",
+			parts: map[string]string{
+				"xl/sharedStrings.xml":     `<sst><si><t>Revenue</t></si><si><r><t>Q1 </t></r><r><t>2024</t></r></si></sst>`,
+				"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c><v>0</v></c></row></sheetData></worksheet>`,
+			},
+			order: []string{"xl/worksheets/sheet1.xml", "xl/sharedStrings.xml"},
+			want:  "Revenue\nQ1 2024\n",
+		},
+		{
+			name: "document with header and footer",
+			parts: map[string]string{
+				"word/document.xml": `<w:document><w:body><w:p><w:r><w:t>Body</w:t></w:r></w:p></w:body></w:document>`,
+				"word/header1.xml":  `<w:hdr><w:p><w:r><w:t>Header</w:t></w:r></w:p></w:hdr>`,
+				"word/footer1.xml":  `<w:ftr><w:p><w:r><w:t>Footer</w:t></w:r></w:p></w:ftr>`,
+				"word/styles.xml":   `<w:styles><w:t>not text</w:t></w:styles>`,
+			},
+			order: []string{"word/header1.xml", "word/styles.xml", "word/footer1.xml", "word/document.xml"},
+			want:  "Body\nFooter\nHeader\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := officeText(officeFixture(t, tt.parts, tt.order))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: officeText = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := officeText(officeFixture(t, map[string]string{"other.xml": "<x/>"}, []string{"other.xml"})); err == nil {
+		t.Error("officeText accepted a ZIP without text parts")
+	}
+	if _, err := officeText([]byte("not a zip")); err == nil {
+		t.Error("officeText accepted something other than a ZIP")
+	}
+}
+
+func TestText(t *testing.T) {
+	docx := officeFixture(t, map[string]string{
+		"word/document.xml": `<w:document><w:body><w:p><w:r><w:t>Invoice</w:t></w:r></w:p></w:body></w:document>`,
+	}, []string{"word/document.xml"})
+	pdf := pdfFixture(t, []string{"BT (Contract) Tj ET"}, []bool{true})
+
+	tests := []struct {
+		name, mimeType string
+		data           []byte
+		want           string
+	}{
+		{"notes.txt", "text/plain", []byte("  hello\x00 world \n"), "hello world"},
+		{"data.csv", "application/octet-stream", []byte("a,b"), "a,b"},
+		{"invalid.txt", "text/plain", []byte("ok\xff\xfe"), "ok"},
+		{"invoice.docx", "application/octet-stream", docx, "Invoice"},
+		{"upload", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", docx, "Invoice"},
+		{"contract.PDF", "", pdf, "Contract"},
+		{"scan", "application/pdf; charset=binary", pdf, "Contract"},
+	}
+	for _, tt := range tests {
+		got, err := Text(bytes.NewReader(tt.data), tt.name, tt.mimeType)
+		if err != nil || got != tt.want {
+			t.Errorf("Text(%s, %s) = %q, %v; want %q", tt.name, tt.mimeType, got, err, tt.want)
+		}
+	}
+
+	if _, err := Text(strings.NewReader("x"), "photo.png", "image/png"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Text of an image: %v, want ErrUnsupported", err)
+	}
+
+	long := strings.Repeat("é", MaxTextSize)
+	got, err := Text(strings.NewReader(long), "long.txt", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > MaxTextSize || !strings.HasPrefix(long, got) || len(got) < MaxTextSize-1 {
+		t.Errorf("long text cut to %d bytes, want whole runes up to %d", len(got), MaxTextSize)
+	}
+}
@@ -0,0 +1,120 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// officeText extracts text from Office Open XML documents (.docx, .xlsx,
+// .pptx). Each is a ZIP archive whose text lives in <t> elements of a few
+// well-known parts; paragraph-like elements become line breaks.
+func officeText(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []*zip.File
+	for _, f := range zr.File {
+		if isOfficeTextPart(f.Name) {
+			parts = append(parts, f)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("no text parts found in office document")
+	}
+	sort.Slice(parts, func(i, j int) bool { return naturalLess(parts[i].Name, parts[j].Name) })
+
+	var out bytes.Buffer
+	for _, f := range parts {
+		if out.Len() >= MaxTextSize {
+			break
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = xmlText(io.LimitReader(rc, MaxInputSize), &out)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func isOfficeTextPart(name string) bool {
+	switch {
+	case name == "word/document.xml",
+		name == "xl/sharedStrings.xml",
+		strings.HasPrefix(name, "word/header") && path.Ext(name) == ".xml",
+		strings.HasPrefix(name, "word/footer") && path.Ext(name) == ".xml",
+		strings.HasPrefix(name, "ppt/slides/slide") && path.Ext(name) == ".xml":
+		return true
+	}
+	return false
+}
+
+// naturalLess orders slide2.xml before slide10.xml.
+func naturalLess(a, b string) bool {
+	pa, na := splitPartNumber(a)
+	pb, nb := splitPartNumber(b)
+	if pa != pb {
+		return pa < pb
+	}
+	return na < nb
+}
+
+func splitPartNumber(name string) (string, int) {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	i := len(base)
+	for i > 0 && base[i-1] >= '0' && base[i-1] <= '9' {
+		i--
+	}
+	n, _ := strconv.Atoi(base[i:])
+	return base[:i], n
+}
+
+func xmlText(r io.Reader, out *bytes.Buffer) error {
+	dec := xml.NewDecoder(r)
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				out.WriteByte('\t')
+			case "br":
+				out.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p", "si":
+				out.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				out.Write(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"unicode/utf16"
+)
+
+// pdfText is a best-effort extractor for text-based PDFs. It inflates every
+// FlateDecode stream and collects the strings shown by the Tj, TJ, ' and "
+// operators inside BT/ET blocks. Scanned documents and fonts with custom
+// encodings yield little or nothing, which only means the file is found by
+// name and description instead of content.
+func pdfText(data []byte) []byte {
+	var out bytes.Buffer
+	for _, stream := range pdfStreams(data) {
+		if out.Len() >= MaxTextSize {
+			break
+		}
+		content := stream
+		if zr, err := zlib.NewReader(bytes.NewReader(stream)); err == nil {
+			inflated, _ := io.ReadAll(io.LimitReader(zr, MaxInputSize))
+			zr.Close()
+			if len(inflated) > 0 {
+				content = inflated
+			}
+		}
+		if bytes.Contains(content, []byte("BT")) {
+			pdfContentText(content, &out)
+		}
+	}
+	return out.Bytes()
+}
+
+// pdfStreams returns the raw bytes between each "stream" and "endstream"
+// keyword pair.
+func pdfStreams(data []byte) [][]byte {
+	var streams [][]byte
+	for {
+		start := bytes.Index(data, []byte("stream"))
+		if start < 0 {
+			return streams
+		}
+		data = data[start+len("stream"):]
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			data = data[2:]
+		} else if bytes.HasPrefix(data, []byte("\n")) {
+			data = data[1:]
+		} else {
+			continue
+		}
+
+		end := bytes.Index(data, []byte("endstream"))
+		if end < 0 {
+			return streams
+		}
+		streams = append(streams, data[:end])
+		data = data[end+len("endstream"):]
+	}
+}
+
+func pdfContentText(content []byte, out *bytes.Buffer) {
+	var pending [][]byte
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := readPDFString(content[i:])
+			pending = append(pending, s)
+			i += n
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFOperatorChar(c):
+			j := i
+			for j < len(content) && isPDFOperatorChar(content[j]) {
+				j++
+			}
+			switch string(content[i:j]) {
+			case "Tj", "TJ", "'", "\"":
+				for _, s := range pending {
+					out.Write(decodePDFString(s))
+				}
+				out.WriteByte(' ')
+				pending = nil
+			case "ET":
+				out.WriteByte('\n')
+				pending = nil
+			default:
+				pending = nil
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+func isPDFOperatorChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*' || c == '\'' || c == '"'
+}
+
+// readPDFString reads a literal string starting at the opening parenthesis,
+// handling nesting and escapes, and returns its bytes and the number of input
+// bytes consumed.
+func readPDFString(b []byte) ([]byte, int) {
+	var s []byte
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				s = append(s, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, i + 1
+			}
+			s = append(s, c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				return s, i
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for k := 0; k < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; k++ {
+						v = v*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(v))
+				} else {
+					s = append(s, e)
+				}
+			}
+		default:
+			s = append(s, c)
+		}
+	}
+	return s, len(b)
+}
+
+// decodePDFString converts a PDF string to UTF-8. Strings with a UTF-16BE
+// byte order mark are decoded as such; everything else is treated as Latin-1,
+// which matches PDFDocEncoding and WinAnsiEncoding for the common characters.
+func decodePDFString(s []byte) []byte {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		units := make([]uint16, 0, (len(s)-2)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return []byte(string(utf16.Decode(units)))
+	}
+
+	out := make([]byte, 0, len(s))
+	for _, c := range s {
+		if c < 0x80 {
+			out = append(out, c)
+		} else {
+			out = append(out, string(rune(c))...)
+		}
+	}
+	return out
+}
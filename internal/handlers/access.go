@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+	"github.com/gorilla/mux"
+)
+
+const recentFilesLimit = 50
+
+// ownedFileFromRequest loads the caller's file named by the {id} route
+// variable, writing an error response and returning nil if it can't.
+func ownedFileFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int) *models.File {
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return nil
+	}
+
+	file, err := models.GetFileByID(db, fileID, userID)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil
+	}
+	return file
+}
+
+// accessibleFileFromRequest is ownedFileFromRequest for files the caller may
+// reach through a grant. It answers 404 when the caller has no access at all
+// and 403 when their role is weaker than required, and returns the role
+// alongside the file.
+func accessibleFileFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int, required string) (*models.File, string) {
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return nil, ""
+	}
+
+	file, role, err := models.GetAccessibleFile(db, fileID, userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to load file %d: %v", fileID, err)
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, ""
+	}
+	if !models.RoleAllows(role, required) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return nil, ""
+	}
+	return file, role
+}
+
+// toAccessibleFileResponse hides the storage location from users who only
+// reach the file through a grant.
+func toAccessibleFileResponse(f *models.File, role string) FileResponse {
+	resp := toFileResponse(f)
+	if role != models.RoleOwner {
+		resp.URL = ""
+	}
+	return resp
+}
+
+// toVisibleFileResponses is toFileResponses for listings that can include
+// files shared by other users.
+func toVisibleFileResponses(files []*models.File, userID int) []FileResponse {
+	response := make([]FileResponse, 0, len(files))
+	for _, f := range files {
+		role := models.RoleOwner
+		if f.UserID != userID {
+			role = models.RoleView
+		}
+		response = append(response, toAccessibleFileResponse(f, role))
+	}
+	return response
+}
+
+func recordAccess(db *sql.DB, fileID, userID int, event string) {
+	if err := models.RecordFileAccess(db, fileID, userID, event); err != nil {
+		log.Printf("Failed to record %s of file %d: %v", event, fileID, err)
+	}
+}
+
+// recordEntriesAccess is recordAccess for the files of an archive.
+func recordEntriesAccess(db *sql.DB, entries []*models.FileEntry, userID int, event string) {
+	ids := make([]int, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	if err := models.RecordFileAccesses(db, ids, userID, event); err != nil {
+		log.Printf("Failed to record %s of %d files: %v", event, len(ids), err)
+	}
+}
+
+// storedFileURL is the location storage.Open expects for a file.
+func storedFileURL(f *models.File) string {
+	if f.S3URL != "" {
+		return f.S3URL
+	}
+	return f.LocalPath
+}
+
+// serveFileContent streams a stored file to the client. disposition is
+// "attachment" to force a download or "inline" to let the browser display it.
+// contentType overrides the type recorded at upload when not empty. Range
+// requests are honoured when the backend can seek, which lets video previews
+// scrub.
+func serveFileContent(w http.ResponseWriter, r *http.Request, storage storage.Storage, f *models.File, disposition, contentType string) {
+	rc, err := storage.Open(storedFileURL(f))
+	if err != nil {
+		log.Printf("Failed to open file %d: %v", f.ID, err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if contentType == "" {
+		contentType = f.Type
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": f.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, f.Name, f.UpdatedAt, rs)
+		return
+	}
+
+	// Without seeking, Range is ignored and the whole file sent.
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("Failed to stream file %d: %v", f.ID, err)
+	}
+}
+
+// GetFileHandler returns a file's metadata and counts as a view.
+func GetFileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file, role := accessibleFileFromRequest(w, r, db, userID, models.RoleView)
+		if file == nil {
+			return
+		}
+
+		recordAccess(db, file.ID, userID, models.AccessView)
+		now := time.Now()
+		file.LastAccessedAt = &now
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toAccessibleFileResponse(file, role))
+	}
+}
+
+func DownloadFileHandler(db *sql.DB, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file, _ := accessibleFileFromRequest(w, r, db, userID, models.RoleDownload)
+		if file == nil {
+			return
+		}
+
+		recordAccess(db, file.ID, userID, models.AccessDownload)
+		serveFileContent(w, r, storage, file, "attachment", "")
+	}
+}
+
+func RecentFilesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		files, err := models.GetRecentFiles(db, userID, recentFilesLimit)
+		if err != nil {
+			http.Error(w, "Failed to get recent files", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toVisibleFileResponses(files, userID))
+	}
+}
+
+func StarredFilesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		files, err := models.GetStarredFiles(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get starred files", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toVisibleFileResponses(files, userID))
+	}
+}
+
+func StarFileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file, _ := accessibleFileFromRequest(w, r, db, userID, models.RoleView)
+		if file == nil {
+			return
+		}
+
+		if err := models.StarFile(db, userID, file.ID); err != nil {
+			http.Error(w, "Failed to star file", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func UnstarFileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid file ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.UnstarFile(db, userID, fileID); err != nil {
+			http.Error(w, "Failed to unstar file", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
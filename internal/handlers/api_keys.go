@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKeyRequest describes a new API key. At most one of ExpiresAt and
+// ExpiresIn may be given; with neither the key doesn't expire. AllowedIPs
+// takes addresses and CIDR ranges.
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ExpiresIn  string     `json:"expires_in"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+// CreateAPIKeyResponse is the only time the key itself is returned; only its
+// hash is stored.
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+const apiKeyPrefixLength = len(auth.APIKeyPrefix) + 8
+
+func (req *CreateAPIKeyRequest) validate(now time.Time) (*time.Time, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		return nil, errors.New("Name is required and must be at most 255 characters")
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("At least one scope is required")
+	}
+	for _, s := range req.Scopes {
+		if !models.IsValidScope(s) {
+			return nil, errors.New("Invalid scope " + strconv.Quote(s) + ", expected files:read, files:write or shares:create")
+		}
+	}
+
+	for i, ip := range req.AllowedIPs {
+		ip = strings.TrimSpace(ip)
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return nil, errors.New("Invalid allowed IP " + strconv.Quote(ip))
+		}
+		req.AllowedIPs[i] = ip
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresIn != "" {
+		return nil, errors.New("Only one of expires_at and expires_in may be set")
+	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.UTC()
+		expiresAt = &t
+	} else if req.ExpiresIn != "" {
+		d, err := parseWithinParam(req.ExpiresIn)
+		if err != nil {
+			return nil, errors.New("Invalid expires_in: " + err.Error())
+		}
+		t := now.Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, errors.New("Expiry must be in the future")
+	}
+	return expiresAt, nil
+}
+
+// ListAPIKeysHandler lists the caller's API keys, revoked ones included.
+func ListAPIKeysHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		keys, err := models.GetAPIKeysByUser(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get API keys", http.StatusInternalServerError)
+			return
+		}
+		if keys == nil {
+			keys = []*models.APIKey{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// CreateAPIKeyHandler issues an API key for the caller.
+func CreateAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		expiresAt, err := req.validate(time.Now().UTC())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := auth.GenerateAPIKey()
+		apiKey := &models.APIKey{
+			UserID:     userID,
+			Name:       req.Name,
+			Prefix:     key[:apiKeyPrefixLength],
+			KeyHash:    auth.HashToken(key),
+			Scopes:     req.Scopes,
+			AllowedIPs: req.AllowedIPs,
+			ExpiresAt:  expiresAt,
+		}
+		if apiKey.AllowedIPs == nil {
+			apiKey.AllowedIPs = []string{}
+		}
+		if err := apiKey.Create(db); err != nil {
+			log.Printf("Failed to create API key for user %d: %v", userID, err)
+			http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+	}
+}
+
+// RevokeAPIKeyHandler revokes one of the caller's API keys. The key stops
+// working immediately.
+func RevokeAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.RevokeAPIKey(db, keyID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/fakubwoy/go-file-share/internal/archive"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+)
+
+const maxArchiveFiles = 10000
+
+// ArchiveRequest selects the files of a bulk download: either FileIDs or
+// everything below FolderID. Format is zip (the default) or tar.gz.
+type ArchiveRequest struct {
+	FileIDs  []int  `json:"file_ids"`
+	FolderID *int   `json:"folder_id"`
+	Format   string `json:"format"`
+}
+
+// writeArchive streams entries as an attachment named name plus the format's
+// extension, returning how many bytes were sent. Once streaming has started
+// the status can't change, so a storage failure midway leaves a truncated
+// archive the client will reject.
+func writeArchive(w http.ResponseWriter, storage storage.Storage, format, name string, entries []*models.FileEntry) (int64, error) {
+	files := make([]archive.Entry, 0, len(entries))
+	for _, e := range entries {
+		f := e.File
+		files = append(files, archive.Entry{
+			Name:    e.Path,
+			Size:    f.Size,
+			Type:    f.Type,
+			ModTime: f.UpdatedAt,
+			Open: func() (io.ReadCloser, error) {
+				return storage.Open(storedFileURL(f))
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", archive.ContentType(format))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	cw := &countingResponseWriter{ResponseWriter: w}
+	err := archive.Write(cw, format, files)
+	return cw.bytes, err
+}
+
+// archiveEntriesFromRequest resolves an archive request to the caller's
+// files and a name for the archive. It writes an error response and returns
+// ok=false if the request is invalid or names files the caller doesn't own.
+func archiveEntriesFromRequest(w http.ResponseWriter, db *sql.DB, userID int, req ArchiveRequest) (entries []*models.FileEntry, name string, ok bool) {
+	if (len(req.FileIDs) == 0) == (req.FolderID == nil) {
+		http.Error(w, "Exactly one of file_ids and folder_id is required", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	if req.FolderID != nil {
+		folder, err := models.GetFolderByID(db, *req.FolderID, userID)
+		if err != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return nil, "", false
+		}
+		entries, err := models.GetFolderTreeFiles(db, folder.ID, userID)
+		if err != nil {
+			http.Error(w, "Failed to list files", http.StatusInternalServerError)
+			return nil, "", false
+		}
+		return entries, folder.Name, true
+	}
+
+	seen := map[int]bool{}
+	var fileIDs []int
+	for _, id := range req.FileIDs {
+		if !seen[id] {
+			seen[id] = true
+			fileIDs = append(fileIDs, id)
+		}
+	}
+	if len(fileIDs) > maxArchiveFiles {
+		http.Error(w, fmt.Sprintf("At most %d files can be downloaded at once", maxArchiveFiles), http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	files, err := models.GetFilesByIDs(db, fileIDs, userID)
+	if err != nil {
+		http.Error(w, "Failed to list files", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if len(files) != len(fileIDs) {
+		http.Error(w, "One or more files not found", http.StatusNotFound)
+		return nil, "", false
+	}
+
+	entries = make([]*models.FileEntry, len(files))
+	for i, f := range files {
+		entries[i] = &models.FileEntry{File: f, Path: f.Name}
+	}
+	return entries, "files", true
+}
+
+// refuseLargeArchive refuses an archive of more than maxArchiveFiles files
+// or, with 413, more than the configured maximum size, and reports whether
+// it did.
+func refuseLargeArchive(w http.ResponseWriter, cfg *config.Config, entries []*models.FileEntry) bool {
+	if len(entries) > maxArchiveFiles {
+		http.Error(w, fmt.Sprintf("At most %d files can be downloaded at once", maxArchiveFiles), http.StatusBadRequest)
+		return true
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	if cfg.ArchiveMaxSize > 0 && total > cfg.ArchiveMaxSize {
+		http.Error(w, fmt.Sprintf("The selected files add up to %s, more than the maximum of %s",
+			formatSize(total), formatSize(cfg.ArchiveMaxSize)), http.StatusRequestEntityTooLarge)
+		return true
+	}
+	return false
+}
+
+// DownloadArchiveHandler streams a set of the caller's files, or a whole
+// folder with its subfolders, as one ZIP or gzipped tar archive. Files are
+// read from storage one after another while the archive is written. Requests
+// whose files add up to more than the configured maximum are refused up front
+// with 413.
+func DownloadArchiveHandler(db *sql.DB, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req ArchiveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Format == "" {
+			req.Format = archive.FormatZip
+		}
+		if !archive.IsValidFormat(req.Format) {
+			http.Error(w, "Invalid format, expected zip or tar.gz", http.StatusBadRequest)
+			return
+		}
+
+		entries, name, ok := archiveEntriesFromRequest(w, db, userID, req)
+		if !ok {
+			return
+		}
+		if len(entries) == 0 {
+			http.Error(w, "There are no files to download", http.StatusNotFound)
+			return
+		}
+
+		if refuseLargeArchive(w, cfg, entries) {
+			return
+		}
+
+		recordEntriesAccess(db, entries, userID, models.AccessDownload)
+		if _, err := writeArchive(w, storage, req.Format, name, entries); err != nil {
+			log.Printf("Failed to stream archive for user %d: %v", userID, err)
+		}
+	}
+}
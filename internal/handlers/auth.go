@@ -3,25 +3,120 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/fakubwoy/go-file-share/internal/auth"
 	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/mail"
 	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
 )
 
 type AuthRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	DisplayName string `json:"display_name,omitempty"`
 }
 
+// AuthResponse carries a short-lived access token (Token, valid for
+// ExpiresIn seconds) and the refresh token to get the next one with.
 type AuthResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+
+	// RecoveryCodes is only set when a login completed enrollment in
+	// two-factor authentication.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// createTokens issues a new access token and refresh token for the user. An
+// empty sessionID starts a new session for the requesting device; otherwise
+// the tokens continue that session.
+func createTokens(r *http.Request, db *sql.DB, cfg *config.Config, keys *auth.KeySet, userID int, sessionID string) (*AuthResponse, error) {
+	if sessionID == "" {
+		session := &models.Session{
+			ID:        auth.GenerateRandomString(32),
+			UserID:    userID,
+			UserAgent: r.UserAgent(),
+			IP:        auth.ClientIP(r, cfg),
+		}
+		if err := session.Create(db); err != nil {
+			return nil, fmt.Errorf("creating session: %w", err)
+		}
+		sessionID = session.ID
+	} else if err := models.TouchSession(db, sessionID, r.UserAgent(), auth.ClientIP(r, cfg)); err != nil {
+		log.Printf("Failed to update session %s: %v", sessionID, err)
+	}
+
+	token, err := auth.GenerateJWTToken(keys, userID, sessionID, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("generating token: %w", err)
+	}
+
+	refreshToken := auth.GenerateRandomString(64)
+	stored := &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  sessionID,
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: time.Now().UTC().Add(cfg.RefreshTokenTTL),
+	}
+	if err := stored.Create(db); err != nil {
+		return nil, fmt.Errorf("storing refresh token: %w", err)
+	}
+
+	return &AuthResponse{
+		Token:        token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.JWTExpiration.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func writeTokens(w http.ResponseWriter, response *AuthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// issueTokens writes new tokens for the user as createTokens makes them.
+func issueTokens(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, keys *auth.KeySet, userID int, sessionID string) {
+	response, err := createTokens(r, db, cfg, keys, userID, sessionID)
+	if err != nil {
+		log.Printf("Error issuing tokens for user %d: %v", userID, err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	writeTokens(w, response)
+}
+
+// refusePasswordLogin answers /register and /login on deployments that
+// only allow single sign-on.
+func refusePasswordLogin(w http.ResponseWriter) {
+	http.Error(w, "Password login is disabled, sign in with single sign-on at /auth/oidc/login", http.StatusForbidden)
 }
 
-func RegisterHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+// RegisterHandler creates an account, mails a link to verify its email and
+// logs it in. Where verification is required, the answer only says so; where
+// two-factor authentication is required, the new user gets an enrollment
+// challenge instead of tokens.
+func RegisterHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet, mailer mail.Mailer, policy *auth.PasswordPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PasswordLoginEnabled {
+			refusePasswordLogin(w)
+			return
+		}
+
 		var req AuthRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			log.Printf("Error decoding request: %v", err)
@@ -29,7 +124,18 @@ func RegisterHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		passwordHash, err := auth.HashPassword(req.Password)
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := policy.Check(req.Password, email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := auth.HashPasswordCost(req.Password, cfg.BcryptCost)
 		if err != nil {
 			log.Printf("Error hashing password: %v", err)
 			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
@@ -37,31 +143,52 @@ func RegisterHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
 		}
 
 		user := &models.User{
-			Email:        req.Email,
+			Email:        email,
 			PasswordHash: passwordHash,
+			DisplayName:  strings.TrimSpace(req.DisplayName),
 		}
 
 		if err := user.Create(db); err != nil {
+			if errors.Is(err, models.ErrEmailTaken) {
+				http.Error(w, "An account with this email already exists", http.StatusConflict)
+				return
+			}
 			log.Printf("Database error creating user: %v", err)
 			http.Error(w, "Failed to create user", http.StatusInternalServerError)
 			return
 		}
 
-		token, err := auth.GenerateJWTToken(user.ID, cfg)
-		if err != nil {
-			log.Printf("Error generating token: %v", err)
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		if err := sendUserToken(r.Context(), db, rdb, cfg, mailer, user, models.TokenPurposeVerifyEmail, user.Email); err != nil {
+			log.Printf("Failed to send verification to user %d: %v", user.ID, err)
+		}
+		if cfg.RequireEmailVerification {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(VerificationRequiredResponse{VerificationRequired: true, Email: user.Email})
 			return
 		}
 
-		response := AuthResponse{Token: token}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		if cfg.Require2FA {
+			writeTwoFactorChallenge(w, r, rdb, user.ID, true)
+			return
+		}
+		issueTokens(w, r, db, cfg, keys, user.ID, "")
 	}
 }
 
-func LoginHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+// LoginHandler checks an email and password. Repeated failures slow down and
+// then lock further attempts, see loginLimit. Password hashes made at a
+// lower cost than configured are upgraded. Where verification is required,
+// unverified accounts are refused. Users with two-factor
+// authentication, or all users if it is required, get a challenge to answer
+// at /login/2fa instead of tokens.
+func LoginHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PasswordLoginEnabled {
+			refusePasswordLogin(w)
+			return
+		}
+
 		var req AuthRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -72,26 +199,141 @@ func LoginHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
 			http.Error(w, "Email and password are required", http.StatusBadRequest)
 			return
 		}
-
-		user, err := models.GetUserByEmail(db, req.Email)
+		email, err := normalizeEmail(req.Email)
 		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limits := loginLimits(cfg, email, auth.ClientIP(r, cfg))
+		if loginBlocked(w, r, rdb, limits) {
+			return
+		}
+
+		// Unknown emails go through the same password check as known ones,
+		// so response times don't reveal which accounts exist.
+		var userID *int
+		passwordHash := ""
+		user, err := models.GetUserByEmail(db, email)
+		if err == nil {
+			userID = &user.ID
+			passwordHash = user.PasswordHash
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up user by email: %v", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
 			return
 		}
 
-		if !auth.CheckPasswordHash(req.Password, user.PasswordHash) {
+		if !auth.VerifyPassword(req.Password, passwordHash, cfg.BcryptCost) {
+			if err := recordLoginFailure(r, db, rdb, cfg, limits, userID, email); err != nil {
+				log.Printf("Failed to record failed login: %v", err)
+			}
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
-		token, err := auth.GenerateJWTToken(user.ID, cfg)
+		if auth.PasswordNeedsRehash(user.PasswordHash, cfg.BcryptCost) {
+			if hash, err := auth.HashPasswordCost(req.Password, cfg.BcryptCost); err != nil {
+				log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+			} else if err := models.UpgradePasswordHash(db, user.ID, user.PasswordHash, hash); err != nil {
+				log.Printf("Failed to upgrade password hash of user %d: %v", user.ID, err)
+			}
+		}
+
+		if cfg.RequireEmailVerification && user.EmailVerifiedAt == nil {
+			http.Error(w, "Email address is not verified, follow the link we sent or request a new one at /email/verify/resend", http.StatusForbidden)
+			return
+		}
+
+		twoFactor, err := models.GetTwoFactor(db, user.ID)
+		if err != nil {
+			log.Printf("Failed to get two-factor state of user %d: %v", user.ID, err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		// With a second factor the failures are cleared once it is given
+		// too, so a known password doesn't reset the count of wrong codes.
+		if twoFactor.Enabled() || cfg.Require2FA {
+			writeTwoFactorChallenge(w, r, rdb, user.ID, !twoFactor.Enabled())
+			return
+		}
+		clearLoginFailures(r.Context(), rdb, limits)
+
+		issueTokens(w, r, db, cfg, keys, user.ID, "")
+	}
+}
+
+// RefreshTokenHandler trades a refresh token for a new access token and a new
+// refresh token; the old one can't be used again. If it is presented again
+// anyway, someone else has a copy, so the whole login is revoked and both
+// parties have to sign in again.
+func RefreshTokenHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "refresh_token is required", http.StatusBadRequest)
+			return
+		}
+
+		token, err := models.GetRefreshTokenByHash(db, auth.HashToken(req.RefreshToken))
 		if err != nil {
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		if err := models.UseRefreshToken(db, token.ID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+				return
+			}
+			// Expired and logged-out tokens are simply refused. A token that
+			// was already used, though, has been replayed.
+			if token.RevokedAt == nil && token.ExpiresAt.After(time.Now()) {
+				log.Printf("Refresh token reuse for user %d, revoking session %s", token.UserID, token.FamilyID)
+				if _, err := endSessions(r.Context(), db, rdb, cfg, token.UserID, []string{token.FamilyID}, ""); err != nil {
+					log.Printf("Failed to revoke session %s: %v", token.FamilyID, err)
+				}
+			}
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
 		}
 
-		response := AuthResponse{Token: token}
+		issueTokens(w, r, db, cfg, keys, token.UserID, token.FamilyID)
+	}
+}
+
+// LogoutHandler ends the session a refresh token belongs to, including the
+// access tokens issued for it.
+func LogoutHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "refresh_token is required", http.StatusBadRequest)
+			return
+		}
+
+		token, err := models.GetRefreshTokenByHash(db, auth.HashToken(req.RefreshToken))
+		if err != nil {
+			// Logging out twice is not an error worth reporting.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, err := endSessions(r.Context(), db, rdb, cfg, token.UserID, []string{token.FamilyID}, ""); err != nil {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// JWKSHandler publishes the public keys access tokens are signed with, so
+// other services can verify them. Keys change rarely; clients should look a
+// kid up again only when they meet one they don't know.
+func JWKSHandler(keys *auth.KeySet) http.HandlerFunc {
+	jwks := keys.JWKS()
+	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(jwks)
 	}
 }
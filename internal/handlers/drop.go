@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type dropPage struct {
+	Token     string
+	Label     string
+	Message   string
+	Owner     string
+	ExpiresAt string
+	Limits    []string
+	Accept    string
+	Error     string
+	Notice    string
+}
+
+// dropMultipartOverhead is allowed on top of the files' sizes in an upload,
+// for the form's other fields and the multipart framing.
+const dropMultipartOverhead = 1 << 20
+
+func newDropPage(db *sql.DB, upload *models.UploadRequest) dropPage {
+	page := dropPage{
+		Token:   upload.Token,
+		Label:   upload.Label,
+		Message: upload.Message,
+		Accept:  strings.Join(upload.AllowedTypes, ","),
+	}
+	if owner, err := models.GetUserByID(db, upload.UserID); err == nil {
+		page.Owner = owner.DisplayName
+	}
+	if upload.ExpiresAt != nil {
+		page.ExpiresAt = upload.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+	if remaining := upload.RemainingFiles(); remaining != nil {
+		if *remaining == 1 {
+			page.Limits = append(page.Limits, "One more file can be uploaded")
+		} else {
+			page.Limits = append(page.Limits, fmt.Sprintf("Up to %d more files can be uploaded", *remaining))
+		}
+	}
+	if upload.MaxFileSize != nil {
+		page.Limits = append(page.Limits, "Each file can be at most "+formatSize(*upload.MaxFileSize))
+	}
+	if len(upload.AllowedTypes) > 0 {
+		page.Limits = append(page.Limits, "Accepted types: "+strings.Join(upload.AllowedTypes, ", "))
+	}
+	return page
+}
+
+// dropAllowsType matches a file against an upload request's allowed types.
+// The declared content type can't be trusted, so MIME entries are matched
+// against the type implied by the file's extension, and files whose type the
+// extension doesn't tell only pass extension entries.
+func dropAllowsType(allowed []string, name string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil || ext == "" {
+		mediaType = ""
+	}
+
+	for _, a := range allowed {
+		switch {
+		case strings.HasPrefix(a, "."):
+			if ext == a {
+				return true
+			}
+		case mediaType == "":
+			// Unknown type: only extension entries can match.
+		case strings.HasSuffix(a, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*")) {
+				return true
+			}
+		case mediaType == a:
+			return true
+		}
+	}
+	return false
+}
+
+// uploadRequestFromRequest resolves the {token} route variable to a drop link
+// that still accepts files, writing the page explaining why not otherwise.
+func uploadRequestFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB) *models.UploadRequest {
+	upload, err := models.GetUploadRequestByToken(db, mux.Vars(r)["token"])
+	if err != nil {
+		renderPage(w, http.StatusNotFound, "share_unavailable.html",
+			shareUnavailablePage{Message: "This link doesn't exist. Check that it was copied completely."})
+		return nil
+	}
+
+	switch upload.Status(time.Now()) {
+	case models.UploadRequestStatusRevoked:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This upload link has been closed by its owner."})
+		return nil
+	case models.UploadRequestStatusExpired:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This upload link has expired."})
+		return nil
+	case models.UploadRequestStatusFull:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This upload link has received all the files it accepts."})
+		return nil
+	}
+	return upload
+}
+
+func dropUnlockCookieName(upload *models.UploadRequest) string {
+	return fmt.Sprintf("drop_unlock_%d", upload.ID)
+}
+
+func dropUnlockKey(upload *models.UploadRequest, value string) string {
+	return fmt.Sprintf("drop_unlock:%d:%s", upload.ID, value)
+}
+
+// isDropUnlocked is isShareUnlocked for drop links: uploads need the cookie
+// from a successful unlock, so nothing is read from anyone without the
+// password.
+func isDropUnlocked(ctx context.Context, r *http.Request, rdb *redis.Client, upload *models.UploadRequest) bool {
+	if upload.PasswordHash == "" {
+		return true
+	}
+	cookie, err := r.Cookie(dropUnlockCookieName(upload))
+	if err != nil {
+		return false
+	}
+	hash, err := rdb.Get(ctx, dropUnlockKey(upload, cookie.Value)).Result()
+	return err == nil && hash == upload.PasswordHash
+}
+
+// DropPageHandler shows the upload form of a file drop link, or the password
+// form first for protected links.
+func DropPageHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upload := uploadRequestFromRequest(w, r, db)
+		if upload == nil {
+			return
+		}
+		if !isDropUnlocked(r.Context(), r, rdb, upload) {
+			renderPage(w, http.StatusUnauthorized, "drop_unlock.html", shareUnlockPage{Token: upload.Token})
+			return
+		}
+		renderPage(w, http.StatusOK, "drop.html", newDropPage(db, upload))
+	}
+}
+
+// DropUnlockHandler checks the password of a protected drop link like
+// UnlockShareHandler does for share links.
+func DropUnlockHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		upload := uploadRequestFromRequest(w, r, db)
+		if upload == nil {
+			return
+		}
+		dropURL := "/drop/" + upload.Token
+		if upload.PasswordHash == "" {
+			http.Redirect(w, r, dropURL, http.StatusSeeOther)
+			return
+		}
+
+		attemptsKey := fmt.Sprintf("drop_unlock_attempts:%d", upload.ID)
+		allowed, err := countUnlockAttempt(ctx, w, rdb, attemptsKey)
+		if err != nil {
+			log.Printf("Failed to count unlock attempt for upload request %d: %v", upload.ID, err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !allowed {
+			renderPage(w, http.StatusTooManyRequests, "drop_unlock.html", shareUnlockPage{
+				Token: upload.Token,
+				Error: "Too many attempts. Please try again later.",
+			})
+			return
+		}
+		if !auth.CheckPasswordHash(r.FormValue("password"), upload.PasswordHash) {
+			renderPage(w, http.StatusUnauthorized, "drop_unlock.html", shareUnlockPage{
+				Token: upload.Token,
+				Error: "Incorrect password.",
+			})
+			return
+		}
+		rdb.Decr(ctx, attemptsKey)
+
+		value := auth.GenerateRandomString(32)
+		if err := rdb.Set(ctx, dropUnlockKey(upload, value), upload.PasswordHash, shareUnlockTTL).Err(); err != nil {
+			http.Error(w, "Failed to unlock upload link", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     dropUnlockCookieName(upload),
+			Value:    value,
+			Path:     dropURL,
+			MaxAge:   int(shareUnlockTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(cfg.ServerBaseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, dropURL, http.StatusSeeOther)
+	}
+}
+
+// dropUploadLimit is the most a single upload to the link may send: what its
+// remaining files may add up to, within DropMaxUploadSize.
+func dropUploadLimit(cfg *config.Config, upload *models.UploadRequest) int64 {
+	limit := cfg.DropMaxUploadSize
+	if remaining := upload.RemainingFiles(); remaining != nil && upload.MaxFileSize != nil {
+		// Compared by division, as the product can overflow.
+		if n := int64(*remaining); n > 0 && *upload.MaxFileSize <= limit/n {
+			limit = n * *upload.MaxFileSize
+		}
+	}
+	return limit + dropMultipartOverhead
+}
+
+// DropUploadHandler accepts files sent through a drop link into the owner's
+// folder, stored exactly like the owner's own uploads. The whole batch is
+// refused if any file breaks the link's limits, so the sender isn't left
+// guessing which files arrived. Protected links need to be unlocked first,
+// see DropUnlockHandler, and the size of the upload is limited before any of
+// it is read.
+func DropUploadHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		upload := uploadRequestFromRequest(w, r, db)
+		if upload == nil {
+			return
+		}
+		page := newDropPage(db, upload)
+		fail := func(status int, msg string) {
+			page.Error = msg
+			renderPage(w, status, "drop.html", page)
+		}
+
+		if !isDropUnlocked(ctx, r, rdb, upload) {
+			renderPage(w, http.StatusUnauthorized, "drop_unlock.html", shareUnlockPage{Token: upload.Token})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, dropUploadLimit(cfg, upload))
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			fail(http.StatusBadRequest, "The upload could not be read. It may be larger than this link accepts.")
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		headers := r.MultipartForm.File["files"]
+		if len(headers) == 0 {
+			fail(http.StatusBadRequest, "Choose at least one file to upload.")
+			return
+		}
+		for _, h := range headers {
+			if upload.MaxFileSize != nil && h.Size > *upload.MaxFileSize {
+				fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s is larger than %s.", h.Filename, formatSize(*upload.MaxFileSize)))
+				return
+			}
+			if !dropAllowsType(upload.AllowedTypes, h.Filename) {
+				fail(http.StatusUnsupportedMediaType, fmt.Sprintf("%s is not a type this link accepts.", h.Filename))
+				return
+			}
+		}
+
+		if err := models.ReserveUploadSlots(db, upload, len(headers)); err != nil {
+			if errors.Is(err, models.ErrUploadRequestFull) {
+				fail(http.StatusConflict, "This link doesn't accept that many more files.")
+				return
+			}
+			http.Error(w, "Failed to upload files", http.StatusInternalServerError)
+			return
+		}
+
+		description := "Uploaded via upload link"
+		if upload.Label != "" {
+			description = fmt.Sprintf("Uploaded via upload link %q", upload.Label)
+		}
+		if sender := strings.TrimSpace(r.FormValue("name")); sender != "" {
+			description += " by " + sender
+		}
+
+		stored, failed := storeDroppedFiles(db, storage, upload, headers, description)
+		if failed > 0 {
+			if err := models.ReleaseUploadSlots(db, upload, failed); err != nil {
+				log.Printf("Failed to release slots of upload request %d: %v", upload.ID, err)
+			}
+		}
+		if stored > 0 {
+			invalidateFileListCache(context.Background(), rdb, upload.UserID)
+			notifyFilesReceived(db, upload, stored)
+		}
+
+		page = newDropPage(db, upload)
+		if failed > 0 {
+			fail(http.StatusInternalServerError, fmt.Sprintf("%d of %d files could not be stored. Please try those again.", failed, len(headers)))
+			return
+		}
+		if stored == 1 {
+			page.Notice = "Your file was uploaded."
+		} else {
+			page.Notice = fmt.Sprintf("Your %d files were uploaded.", stored)
+		}
+		renderPage(w, http.StatusCreated, "drop.html", page)
+	}
+}
+
+func storeDroppedFiles(db *sql.DB, storage storage.Storage, upload *models.UploadRequest, headers []*multipart.FileHeader, description string) (stored, failed int) {
+	for _, h := range headers {
+		f := &models.File{
+			UserID:      upload.UserID,
+			FolderID:    &upload.FolderID,
+			Description: description,
+		}
+		if err := storeUpload(db, storage, h, f); err != nil {
+			log.Printf("Failed to store file dropped through upload request %d: %v", upload.ID, err)
+			failed++
+			continue
+		}
+		stored++
+	}
+	return stored, failed
+}
+
+func notifyFilesReceived(db *sql.DB, upload *models.UploadRequest, count int) {
+	name := upload.Label
+	if name == "" {
+		name = "your upload link"
+	} else {
+		name = fmt.Sprintf("%q", name)
+	}
+	message := fmt.Sprintf("%d files were uploaded through %s", count, name)
+	if count == 1 {
+		message = fmt.Sprintf("A file was uploaded through %s", name)
+	}
+
+	n := &models.Notification{
+		UserID:  upload.UserID,
+		Kind:    models.NotificationFilesReceived,
+		Message: message,
+		URL:     fmt.Sprintf("/folders/%d", upload.FolderID),
+	}
+	if err := n.Create(db); err != nil {
+		log.Printf("Failed to notify user %d of dropped files: %v", upload.UserID, err)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import "testing"
+
+func TestDropAllowsType(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		name    string
+		want    bool
+	}{
+		{nil, "anything.exe", true},
+		{[]string{"image/*"}, "photo.png", true},
+		{[]string{"image/*"}, "photo.PNG", true},
+		{[]string{"image/*"}, "report.pdf", false},
+		{[]string{"image/*"}, "x.foo", false},
+		{[]string{"image/*"}, "noextension", false},
+		{[]string{"application/pdf"}, "report.pdf", true},
+		{[]string{"application/pdf"}, "report.pdf.exe", false},
+		{[]string{".foo"}, "x.foo", true},
+		{[]string{"image/*", ".foo"}, "x.foo", true},
+		{[]string{".pdf"}, "report.txt", false},
+	}
+	for _, tt := range tests {
+		if got := dropAllowsType(tt.allowed, tt.name); got != tt.want {
+			t.Errorf("dropAllowsType(%q, %q) = %v, want %v", tt.allowed, tt.name, got, tt.want)
+		}
+	}
+}
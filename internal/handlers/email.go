@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/mail"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// mailThrottle is the least time between two messages of the same kind to
+// one user, so the public endpoints that send mail can't be used to flood
+// someone's inbox.
+const mailThrottle = time.Minute
+
+const mailSendTimeout = 30 * time.Second
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// VerificationRequiredResponse answers a registration when the account can't
+// log in before its email is verified.
+type VerificationRequiredResponse struct {
+	VerificationRequired bool   `json:"verification_required"`
+	Email                string `json:"email"`
+}
+
+type accountMessagePage struct {
+	Title   string
+	Message string
+	Error   bool
+}
+
+var errInvalidEmail = errors.New("Invalid email address")
+
+// normalizeEmail checks that s is a bare email address such as
+// jane@example.com, without a display name or comments.
+func normalizeEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || len(s) > 254 {
+		return "", errInvalidEmail
+	}
+	addr, err := netmail.ParseAddress(s)
+	if err != nil || addr.Name != "" || addr.Address != s {
+		return "", errInvalidEmail
+	}
+	at := strings.LastIndex(s, "@")
+	if at < 1 || !strings.Contains(s[at+1:], ".") {
+		return "", errInvalidEmail
+	}
+	return s, nil
+}
+
+// sendUserToken mails the user a link carrying a new single-use token. The
+// message is sent in the background, so a slow mail server doesn't hold up
+// the request or reveal through timing whether an account exists. Requests
+// for a user that already got a message of this kind within mailThrottle are
+// dropped.
+func sendUserToken(ctx context.Context, db *sql.DB, rdb *redis.Client, cfg *config.Config, mailer mail.Mailer, user *models.User, purpose, email string) error {
+	throttleKey := fmt.Sprintf("mail_throttle:%s:%d", purpose, user.ID)
+	first, err := rdb.SetNX(ctx, throttleKey, 1, mailThrottle).Result()
+	if err != nil {
+		return err
+	}
+	if !first {
+		return nil
+	}
+
+	ttl := cfg.EmailVerificationTTL
+	if purpose == models.TokenPurposeResetPassword {
+		ttl = cfg.PasswordResetTTL
+	}
+	token := auth.GenerateRandomString(48)
+	stored := &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(token),
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	if err := stored.Create(db); err != nil {
+		return err
+	}
+
+	msg := userTokenMessage(cfg, purpose, email, token, ttl)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %s mail to user %d: %v", purpose, user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func userTokenMessage(cfg *config.Config, purpose, email, token string, ttl time.Duration) mail.Message {
+	base := strings.TrimSuffix(cfg.ServerBaseURL, "/")
+	query := "?token=" + url.QueryEscape(token)
+
+	if purpose == models.TokenPurposeResetPassword {
+		return mail.Message{
+			To:      email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new one, open\n\n"+
+				"%s/password/reset%s\n\n"+
+				"The link works once and expires in %s. If you didn't ask for this, ignore this message; "+
+				"your password stays the same.\n", base, query, formatTTL(ttl)),
+		}
+	}
+	if purpose == models.TokenPurposeChangeEmail {
+		return mail.Message{
+			To:      email,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf("Someone asked to change the email address of your account to this one. To confirm, open\n\n"+
+				"%s/email/verify%s\n\n"+
+				"The link expires in %s. Until then your account keeps its old address. If you didn't ask for this, "+
+				"ignore this message.\n", base, query, formatTTL(ttl)),
+		}
+	}
+	return mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Please confirm that this is your email address by opening\n\n"+
+			"%s/email/verify%s\n\n"+
+			"The link expires in %s. If you didn't create an account, ignore this message.\n", base, query, formatTTL(ttl)),
+	}
+}
+
+// formatTTL writes a token lifetime the way people say it: "1 hour",
+// "48 hours", "30 minutes".
+func formatTTL(d time.Duration) string {
+	unit, n := "minute", int(d/time.Minute)
+	if d >= time.Hour && d%time.Hour == 0 {
+		unit, n = "hour", int(d/time.Hour)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return strconv.Itoa(n) + " " + unit
+}
+
+// verifyEmail uses a verification token, or an email change token, which
+// switches the account to the address it was sent to. It reports false for
+// unknown, used or expired tokens, for tokens sent to an address the user no
+// longer has, and for changes to an address another account took meanwhile.
+func verifyEmail(db *sql.DB, token string) (bool, error) {
+	tokenHash := auth.HashToken(token)
+	stored, err := models.UseUserToken(db, models.TokenPurposeVerifyEmail, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		stored, err = models.UseUserToken(db, models.TokenPurposeChangeEmail, tokenHash)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if stored.Purpose == models.TokenPurposeChangeEmail {
+		err = models.ChangeEmail(db, stored.UserID, stored.Email)
+		if errors.Is(err, models.ErrEmailTaken) {
+			log.Printf("Email change of user %d failed, the address is taken", stored.UserID)
+			return false, nil
+		}
+		if err == nil {
+			if err := models.InvalidateUserTokens(db, stored.UserID, models.TokenPurposeChangeEmail); err != nil {
+				log.Printf("Failed to invalidate email changes of user %d: %v", stored.UserID, err)
+			}
+		}
+	} else {
+		err = models.SetEmailVerified(db, stored.UserID, stored.Email)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// VerifyEmailPageHandler is where the link in a verification email leads.
+func VerifyEmailPageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, err := verifyEmail(db, r.URL.Query().Get("token"))
+		if err != nil {
+			log.Printf("Failed to verify email: %v", err)
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			renderPage(w, http.StatusBadRequest, "account_message.html", accountMessagePage{
+				Title:   "Link invalid",
+				Message: "This verification link is invalid, was already used or has expired. You can request a new one.",
+				Error:   true,
+			})
+			return
+		}
+		renderPage(w, http.StatusOK, "account_message.html", accountMessagePage{
+			Title:   "Email verified",
+			Message: "Thank you, your email address is verified.",
+		})
+	}
+}
+
+// VerifyEmailHandler verifies an email address with the token from the link,
+// for clients that handle the link themselves.
+func VerifyEmailHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "token is required", http.StatusBadRequest)
+			return
+		}
+
+		ok, err := verifyEmail(db, req.Token)
+		if err != nil {
+			log.Printf("Failed to verify email: %v", err)
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResendVerificationHandler sends a new verification link. It answers the
+// same whether or not the address belongs to an unverified account, so it
+// can't be used to find out who has one.
+func ResendVerificationHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByEmail(db, email)
+		if err == nil && user.EmailVerifiedAt == nil {
+			if err := sendUserToken(r.Context(), db, rdb, cfg, mailer, user, models.TokenPurposeVerifyEmail, user.Email); err != nil {
+				log.Printf("Failed to send verification to user %d: %v", user.ID, err)
+			}
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up user by email: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
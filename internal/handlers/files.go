@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha1"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/fakubwoy/go-file-share/internal/auth"
 	"github.com/fakubwoy/go-file-share/internal/config"
 	"github.com/fakubwoy/go-file-share/internal/models"
 	"github.com/fakubwoy/go-file-share/internal/storage"
@@ -18,13 +24,24 @@ import (
 )
 
 type FileResponse struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Size      int64     `json:"size"`
-	Type      string    `json:"type"`
-	URL       string    `json:"url"`
-	IsPublic  bool      `json:"is_public"`
-	CreatedAt time.Time `json:"created_at"`
+	ID             int        `json:"id"`
+	FolderID       *int       `json:"folder_id"`
+	Name           string     `json:"name"`
+	Size           int64      `json:"size"`
+	Type           string     `json:"type"`
+	Description    string     `json:"description"`
+	Tags           []string   `json:"tags"`
+	URL            string     `json:"url"`
+	IsPublic       bool       `json:"is_public"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	DownloadCount  int64      `json:"download_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type SearchResultResponse struct {
+	FileResponse
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet,omitempty"`
 }
 
 type UploadResponse struct {
@@ -32,8 +49,27 @@ type UploadResponse struct {
 	File    FileResponse `json:"file"`
 }
 
-type ShareResponse struct {
-	ShareURL string `json:"share_url"`
+// storeUpload writes an uploaded file to storage under f.UserID and records
+// it, taking name, size and type from the upload.
+func storeUpload(db *sql.DB, storage storage.Storage, fileHeader *multipart.FileHeader, f *models.File) error {
+	fileURL, err := storage.UploadFile(fileHeader, f.UserID)
+	if err != nil {
+		return err
+	}
+
+	f.Name = fileHeader.Filename
+	f.Size = fileHeader.Size
+	f.Type = fileHeader.Header.Get("Content-Type")
+	f.S3URL = fileURL
+	if err := f.Create(db); err != nil {
+		// Nothing refers to the contents, e.g. because the owner was deleted
+		// meanwhile.
+		if err := storage.Delete(fileURL); err != nil {
+			log.Printf("Failed to delete %s of a file that wasn't created: %v", fileURL, err)
+		}
+		return err
+	}
+	return nil
 }
 
 func UploadHandler(db *sql.DB, cfg *config.Config, storage storage.Storage, rdb *redis.Client) http.HandlerFunc {
@@ -53,27 +89,32 @@ func UploadHandler(db *sql.DB, cfg *config.Config, storage storage.Storage, rdb
 		}
 		defer file.Close()
 
-		resultChan := make(chan *models.File)
-		errChan := make(chan error)
-
-		go func() {
-			fileURL, err := storage.UploadFile(fileHeader, userID)
+		var folderID *int
+		if v := r.FormValue("folder_id"); v != "" {
+			id, err := strconv.Atoi(v)
 			if err != nil {
-				errChan <- err
+				http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+				return
+			}
+			if _, err := models.GetFolderByID(db, id, userID); err != nil {
+				http.Error(w, "Folder not found", http.StatusNotFound)
 				return
 			}
+			folderID = &id
+		}
+
+		resultChan := make(chan *models.File)
+		errChan := make(chan error)
 
+		go func() {
 			newFile := &models.File{
-				UserID:     userID,
-				Name:       fileHeader.Filename,
-				Size:       fileHeader.Size,
-				Type:       fileHeader.Header.Get("Content-Type"),
-				S3URL:      fileURL,
-				IsPublic:   false,
-				ShareToken: "",
+				UserID:      userID,
+				FolderID:    folderID,
+				Description: r.FormValue("description"),
+				Tags:        parseTags(r.FormValue("tags")),
 			}
 
-			if err := newFile.Create(db); err != nil {
+			if err := storeUpload(db, storage, fileHeader, newFile); err != nil {
 				errChan <- err
 				return
 			}
@@ -83,21 +124,11 @@ func UploadHandler(db *sql.DB, cfg *config.Config, storage storage.Storage, rdb
 
 		select {
 		case newFile := <-resultChan:
-			ctx := context.Background()
-			cacheKey := fmt.Sprintf("user_files:%d", userID)
-			rdb.Del(ctx, cacheKey)
+			invalidateFileListCache(context.Background(), rdb, userID)
 
 			response := UploadResponse{
 				Message: "File uploaded successfully",
-				File: FileResponse{
-					ID:        newFile.ID,
-					Name:      newFile.Name,
-					Size:      newFile.Size,
-					Type:      newFile.Type,
-					URL:       newFile.S3URL,
-					IsPublic:  newFile.IsPublic,
-					CreatedAt: newFile.CreatedAt,
-				},
+				File:    toFileResponse(newFile),
 			}
 
 			w.Header().Set("Content-Type", "application/json")
@@ -109,126 +140,350 @@ func UploadHandler(db *sql.DB, cfg *config.Config, storage storage.Storage, rdb
 	}
 }
 
-func ListFilesHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userID := r.Context().Value("userID").(int)
-		ctx := context.Background()
-		cacheKey := fmt.Sprintf("user_files:%d", userID)
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	fileListTTL     = 5 * time.Minute
+)
 
-		cachedFiles, err := rdb.Get(ctx, cacheKey).Result()
-		if err == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(cachedFiles))
-			return
-		}
+type FileListResponse struct {
+	Files        []FileResponse        `json:"files"`
+	NextCursor   string                `json:"next_cursor,omitempty"`
+	TotalCount   int                   `json:"total_count"`
+	SmartFolders []SmartFolderResponse `json:"smart_folders,omitempty"`
+}
+
+func toFileResponse(f *models.File) FileResponse {
+	var fileURL string
+	if f.S3URL != "" {
+		fileURL = f.S3URL
+	} else {
+		fileURL = f.LocalPath
+	}
+
+	return FileResponse{
+		ID:             f.ID,
+		FolderID:       f.FolderID,
+		Name:           f.Name,
+		Size:           f.Size,
+		Type:           f.Type,
+		Description:    f.Description,
+		Tags:           f.Tags,
+		URL:            fileURL,
+		IsPublic:       f.IsPublic,
+		LastAccessedAt: f.LastAccessedAt,
+		DownloadCount:  f.DownloadCount,
+		CreatedAt:      f.CreatedAt,
+	}
+}
+
+func toFileResponses(files []*models.File) []FileResponse {
+	response := make([]FileResponse, 0, len(files))
+	for _, f := range files {
+		response = append(response, toFileResponse(f))
+	}
+	return response
+}
+
+// Cached listing pages are keyed by a per-user version number, so a single
+// INCR invalidates every page without having to find and delete them.
+func fileListVersionKey(userID int) string {
+	return fmt.Sprintf("user_files_version:%d", userID)
+}
+
+func fileListCacheKey(ctx context.Context, rdb *redis.Client, userID int, scope string, query url.Values) string {
+	version, err := rdb.Get(ctx, fileListVersionKey(userID)).Result()
+	if err != nil {
+		version = "0"
+	}
+	sum := sha1.Sum([]byte(query.Encode()))
+	return fmt.Sprintf("user_files:%d:%s:%s:%s", userID, version, scope, hex.EncodeToString(sum[:]))
+}
+
+func invalidateFileListCache(ctx context.Context, rdb *redis.Client, userID int) {
+	rdb.Incr(ctx, fileListVersionKey(userID))
+}
+
+func parseFileFilter(q url.Values) (models.FileFilter, error) {
+	var filter models.FileFilter
+	var err error
 
-		files, err := models.GetFilesByUser(db, userID)
+	filter.MimeType = q.Get("type")
+	filter.Tags = parseTags(strings.Join(q["tag"], ","))
+	if len(filter.Tags) == 0 {
+		filter.Tags = nil
+	}
+
+	if v := q.Get("min_size"); v != "" {
+		if filter.MinSize, err = strconv.ParseInt(v, 10, 64); err != nil || filter.MinSize < 0 {
+			return filter, fmt.Errorf("invalid min_size")
+		}
+	}
+	if v := q.Get("max_size"); v != "" {
+		if filter.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || filter.MaxSize < 0 {
+			return filter, fmt.Errorf("invalid max_size")
+		}
+	}
+	if v := q.Get("created_after"); v != "" {
+		if filter.CreatedAfter, err = parseDateParam(v); err != nil {
+			return filter, fmt.Errorf("invalid created_after")
+		}
+	}
+	if v := q.Get("created_before"); v != "" {
+		if filter.CreatedBefore, err = parseDateParam(v); err != nil {
+			return filter, fmt.Errorf("invalid created_before")
+		}
+	}
+	if v := q.Get("created_within"); v != "" {
+		if q.Get("created_after") != "" {
+			return filter, fmt.Errorf("created_within and created_after cannot be combined")
+		}
+		within, err := parseWithinParam(v)
 		if err != nil {
-			http.Error(w, "Failed to get files", http.StatusInternalServerError)
-			return
+			return filter, fmt.Errorf("invalid created_within")
 		}
+		filter.CreatedAfter = time.Now().UTC().Add(-within)
+	}
+	return filter, nil
+}
 
-		var response []FileResponse
-		for _, f := range files {
-			var url string
-			if f.S3URL != "" {
-				url = f.S3URL
-			} else {
-				url = f.LocalPath
-			}
-
-			response = append(response, FileResponse{
-				ID:        f.ID,
-				Name:      f.Name,
-				Size:      f.Size,
-				Type:      f.Type,
-				URL:       url,
-				IsPublic:  f.IsPublic,
-				CreatedAt: f.CreatedAt,
-			})
+// parseWithinParam parses a relative period such as "30d" or "12h". Days are
+// accepted on top of time.ParseDuration's units since they are what people
+// filter by.
+func parseWithinParam(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
 		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("period must be positive")
+	}
+	return d, err
+}
+
+// parseDateParam accepts either a full RFC 3339 timestamp or a plain date.
+func parseDateParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", v)
+}
 
-		jsonResponse, err := json.Marshal(response)
-		if err == nil {
-			rdb.Set(ctx, cacheKey, jsonResponse, 5*time.Minute)
+func parseFileListOptions(q url.Values) (models.FileListOptions, error) {
+	opts := models.FileListOptions{
+		SortBy: "created_at",
+		Desc:   true,
+		Cursor: q.Get("cursor"),
+	}
+
+	filter, err := parseFileFilter(q)
+	if err != nil {
+		return opts, err
+	}
+	opts.FileFilter = filter
+
+	if v := q.Get("sort"); v != "" {
+		if !models.IsValidSortKey(v) {
+			return opts, fmt.Errorf("invalid sort, expected one of name, size, created_at, updated_at")
 		}
+		opts.SortBy = v
+	}
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		opts.Desc = false
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid order, expected asc or desc")
+	}
+	if opts.Limit, err = parseLimit(q); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+// parseTags splits a comma-separated tag list, normalising case and dropping
+// blanks and duplicates.
+func parseTags(raw string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
 	}
+	return tags
 }
 
-func SearchFilesHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+func ListFilesHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("userID").(int)
-		query := r.URL.Query().Get("q")
+		writeFileList(w, db, rdb, userID, r.URL.Query(), "files")
+	}
+}
 
-		if query == "" {
-			http.Error(w, "Search query is required", http.StatusBadRequest)
-			return
-		}
+// writeFileList serves one page of the user's files filtered by q. scope keeps
+// cache entries of different callers apart; only the plain "files" listing
+// shows smart folders, and only on its first page.
+func writeFileList(w http.ResponseWriter, db *sql.DB, rdb *redis.Client, userID int, q url.Values, scope string) {
+	ctx := context.Background()
+
+	opts, err := parseFileListOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheKey := fileListCacheKey(ctx, rdb, userID, scope, q)
+	cachedPage, err := rdb.Get(ctx, cacheKey).Bytes()
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(withCurrentAccessStats(db, cachedPage))
+		return
+	}
+
+	page, err := models.ListFiles(db, userID, opts)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get files", http.StatusInternalServerError)
+		return
+	}
+
+	response := FileListResponse{
+		Files:      toFileResponses(page.Files),
+		NextCursor: page.NextCursor,
+		TotalCount: page.TotalCount,
+	}
 
-		files, err := models.SearchFiles(db, userID, query)
+	if scope == "files" && opts.Cursor == "" {
+		searches, err := models.GetSavedSearchesByUser(db, userID)
 		if err != nil {
-			http.Error(w, "Failed to search files", http.StatusInternalServerError)
+			http.Error(w, "Failed to get smart folders", http.StatusInternalServerError)
 			return
 		}
+		for _, s := range searches {
+			response.SmartFolders = append(response.SmartFolders, toSmartFolderResponse(s))
+		}
+	}
 
-		var response []FileResponse
-		for _, f := range files {
-			var url string
-			if f.S3URL != "" {
-				url = f.S3URL
-			} else {
-				url = f.LocalPath
-			}
+	jsonResponse, err := json.Marshal(response)
+	if err == nil {
+		rdb.Set(ctx, cacheKey, jsonResponse, fileListTTL)
+	}
 
-			response = append(response, FileResponse{
-				ID:        f.ID,
-				Name:      f.Name,
-				Size:      f.Size,
-				Type:      f.Type,
-				URL:       url,
-				IsPublic:  f.IsPublic,
-				CreatedAt: f.CreatedAt,
-			})
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+// withCurrentAccessStats brings the access times and download counts of a
+// cached listing page up to date. Views and downloads don't invalidate the
+// cache, or a busy share link would keep its owner's listings from being
+// cached at all. If the page can't be updated it is returned as it was.
+func withCurrentAccessStats(db *sql.DB, page []byte) []byte {
+	var response FileListResponse
+	if err := json.Unmarshal(page, &response); err != nil || len(response.Files) == 0 {
+		return page
+	}
+	ids := make([]int, len(response.Files))
+	for i, f := range response.Files {
+		ids[i] = f.ID
 	}
+	stats, err := models.GetFileAccessStats(db, ids)
+	if err != nil {
+		log.Printf("Failed to get access stats of cached files: %v", err)
+		return page
+	}
+	for i := range response.Files {
+		if s, ok := stats[response.Files[i].ID]; ok {
+			response.Files[i].LastAccessedAt = s.LastAccessedAt
+			response.Files[i].DownloadCount = s.DownloadCount
+		}
+	}
+	updated, err := json.Marshal(response)
+	if err != nil {
+		return page
+	}
+	return updated
 }
 
-func ShareFileHandler(db *sql.DB, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+func SearchFilesHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value("userID").(int)
-		vars := mux.Vars(r)
-		fileID, err := strconv.Atoi(vars["id"])
-		if err != nil {
-			http.Error(w, "Invalid file ID", http.StatusBadRequest)
-			return
-		}
+		writeSearchResults(w, db, userID, r.URL.Query())
+	}
+}
 
-		_, err = models.GetFileByID(db, fileID, userID)
-		if err != nil {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
+func parseLimit(q url.Values) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
 
-		token := auth.GenerateRandomString(32)
-		expiresAt := time.Now().Add(24 * time.Hour)
+func writeSearchResults(w http.ResponseWriter, db *sql.DB, userID int, q url.Values) {
+	query := q.Get("q")
+	if query == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
 
-		if err := models.MakeFilePublic(db, fileID, userID, token, expiresAt); err != nil {
-			http.Error(w, "Failed to share file", http.StatusInternalServerError)
-			return
-		}
+	limit, err := parseLimit(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseFileFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		shareURL := fmt.Sprintf("%s/share/%s", cfg.ServerBaseURL, token)
-		response := ShareResponse{ShareURL: shareURL}
+	var results []*models.FileSearchResult
+	switch q.Get("mode") {
+	case "", "fulltext":
+		results, err = models.SearchFiles(db, userID, query, filter, limit)
+	case "fuzzy":
+		results, err = models.FuzzySearchFiles(db, userID, query, filter, limit)
+	default:
+		http.Error(w, "Invalid mode, expected fulltext or fuzzy", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to search files", http.StatusInternalServerError)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	response := make([]SearchResultResponse, 0, len(results))
+	for _, res := range results {
+		response = append(response, SearchResultResponse{
+			FileResponse: toFileResponse(res.File),
+			Rank:         res.Rank,
+			Snippet:      res.Snippet,
+		})
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func DeleteFileHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
@@ -246,10 +501,76 @@ func DeleteFileHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
 			return
 		}
 
-		ctx := context.Background()
-		cacheKey := fmt.Sprintf("user_files:%d", userID)
-		rdb.Del(ctx, cacheKey)
+		invalidateFileListCache(context.Background(), rdb, userID)
 
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// UpdateFileRequest changes a file's metadata. Fields left out are kept.
+// FolderID 0 moves the file out of its folder.
+type UpdateFileRequest struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	FolderID    *int      `json:"folder_id"`
+}
+
+// UpdateFileHandler edits a file's name, description and tags, which users
+// granted the edit role may do too. Only the owner can move it between
+// folders.
+func UpdateFileHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file, role := accessibleFileFromRequest(w, r, db, userID, models.RoleEdit)
+		if file == nil {
+			return
+		}
+
+		var req UpdateFileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" || strings.ContainsAny(name, "/\\") {
+				http.Error(w, "Invalid file name", http.StatusBadRequest)
+				return
+			}
+			file.Name = name
+		}
+		if req.Description != nil {
+			file.Description = *req.Description
+		}
+		if req.Tags != nil {
+			file.Tags = parseTags(strings.Join(*req.Tags, ","))
+		}
+		if req.FolderID != nil {
+			if role != models.RoleOwner {
+				http.Error(w, "Only the owner can move a file", http.StatusForbidden)
+				return
+			}
+			if *req.FolderID == 0 {
+				file.FolderID = nil
+			} else {
+				if _, err := models.GetFolderByID(db, *req.FolderID, userID); err != nil {
+					http.Error(w, "Folder not found", http.StatusNotFound)
+					return
+				}
+				file.FolderID = req.FolderID
+			}
+		}
+
+		if err := models.UpdateFile(db, file); err != nil {
+			http.Error(w, "Failed to update file", http.StatusInternalServerError)
+			return
+		}
+		invalidateFileListCache(context.Background(), rdb, file.UserID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toFileResponse(file))
+	}
+}
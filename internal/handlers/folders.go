@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// FolderRequest creates or changes a folder. On update, fields left out are
+// kept and ParentID 0 moves the folder to the top level.
+type FolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *int    `json:"parent_id"`
+}
+
+type FolderResponse struct {
+	ID        int       `json:"id"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderContentsResponse is a folder with what is directly inside it and the
+// caller's role on it.
+type FolderContentsResponse struct {
+	FolderResponse
+	Role    string           `json:"role"`
+	Folders []FolderResponse `json:"folders"`
+	Files   []FileResponse   `json:"files"`
+}
+
+func toFolderResponse(f *models.Folder) FolderResponse {
+	return FolderResponse{
+		ID:        f.ID,
+		ParentID:  f.ParentID,
+		Name:      f.Name,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+func toFolderResponses(folders []*models.Folder) []FolderResponse {
+	response := make([]FolderResponse, 0, len(folders))
+	for _, f := range folders {
+		response = append(response, toFolderResponse(f))
+	}
+	return response
+}
+
+func validFolderName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && !strings.ContainsAny(name, "/\\")
+}
+
+// ownedFolderFromRequest loads the caller's folder named by the {id} route
+// variable, writing an error response and returning nil if it can't.
+func ownedFolderFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int) *models.Folder {
+	folderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return nil
+	}
+
+	folder, err := models.GetFolderByID(db, folderID, userID)
+	if err != nil {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return nil
+	}
+	return folder
+}
+
+func writeFolderError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, models.ErrDuplicateName):
+		http.Error(w, "A folder with this name already exists here", http.StatusConflict)
+	case errors.Is(err, models.ErrFolderCycle):
+		http.Error(w, "A folder cannot be moved into itself", http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to "+action+" folder", http.StatusInternalServerError)
+	}
+}
+
+func CreateFolderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req FolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Name == nil {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		name, ok := validFolderName(*req.Name)
+		if !ok {
+			http.Error(w, "Invalid folder name", http.StatusBadRequest)
+			return
+		}
+
+		folder := &models.Folder{UserID: userID, Name: name}
+		if req.ParentID != nil && *req.ParentID != 0 {
+			if _, err := models.GetFolderByID(db, *req.ParentID, userID); err != nil {
+				http.Error(w, "Parent folder not found", http.StatusNotFound)
+				return
+			}
+			folder.ParentID = req.ParentID
+		}
+
+		if err := folder.Create(db); err != nil {
+			writeFolderError(w, err, "create")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(toFolderResponse(folder))
+	}
+}
+
+// ListFoldersHandler returns all of the caller's folders as a flat list;
+// parent_id links them into a tree.
+func ListFoldersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folders, err := models.GetFoldersByUser(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get folders", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toFolderResponses(folders))
+	}
+}
+
+// GetFolderHandler lists a folder's subfolders and files, for its owner and
+// for anyone granted access to it or a folder above it.
+func GetFolderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		folderID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+			return
+		}
+
+		folder, role, err := models.GetAccessibleFolder(db, folderID, userID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to load folder %d: %v", folderID, err)
+			}
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+
+		subfolders, err := models.GetSubfolders(db, folder.ID)
+		if err != nil {
+			http.Error(w, "Failed to get folder contents", http.StatusInternalServerError)
+			return
+		}
+		files, err := models.GetFilesInFolder(db, folder.ID)
+		if err != nil {
+			http.Error(w, "Failed to get folder contents", http.StatusInternalServerError)
+			return
+		}
+
+		response := FolderContentsResponse{
+			FolderResponse: toFolderResponse(folder),
+			Role:           role,
+			Folders:        toFolderResponses(subfolders),
+			Files:          make([]FileResponse, 0, len(files)),
+		}
+		for _, f := range files {
+			response.Files = append(response.Files, toAccessibleFileResponse(f, role))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func UpdateFolderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+
+		var req FolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Name != nil {
+			name, ok := validFolderName(*req.Name)
+			if !ok {
+				http.Error(w, "Invalid folder name", http.StatusBadRequest)
+				return
+			}
+			folder.Name = name
+		}
+		if req.ParentID != nil {
+			if *req.ParentID == 0 {
+				folder.ParentID = nil
+			} else {
+				if _, err := models.GetFolderByID(db, *req.ParentID, userID); err != nil {
+					http.Error(w, "Parent folder not found", http.StatusNotFound)
+					return
+				}
+				folder.ParentID = req.ParentID
+			}
+		}
+
+		if err := folder.Update(db); err != nil {
+			writeFolderError(w, err, "update")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toFolderResponse(folder))
+	}
+}
+
+// DeleteFolderHandler removes an empty folder; the caller has to move or
+// delete its contents first.
+func DeleteFolderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+
+		if err := models.DeleteFolder(db, folder.ID, userID); err != nil {
+			switch {
+			case errors.Is(err, models.ErrFolderNotEmpty):
+				http.Error(w, "Folder is not empty", http.StatusConflict)
+			case errors.Is(err, sql.ErrNoRows):
+				http.Error(w, "Folder not found", http.StatusNotFound)
+			default:
+				http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
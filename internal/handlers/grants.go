@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// GrantRequest gives the registered user with Email a role on a file or
+// folder. Granting again changes the role.
+type GrantRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type GrantResponse struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SharedFileResponse struct {
+	FileResponse
+	Role       string `json:"role"`
+	OwnerEmail string `json:"owner_email"`
+	OwnerName  string `json:"owner_name"`
+}
+
+type SharedFolderResponse struct {
+	FolderResponse
+	Role       string `json:"role"`
+	OwnerEmail string `json:"owner_email"`
+	OwnerName  string `json:"owner_name"`
+}
+
+type SharedWithMeResponse struct {
+	Files   []SharedFileResponse   `json:"files"`
+	Folders []SharedFolderResponse `json:"folders"`
+}
+
+func toGrantResponse(g *models.Grant) GrantResponse {
+	return GrantResponse{
+		ID:          g.ID,
+		UserID:      g.GranteeID,
+		Email:       g.GranteeEmail,
+		DisplayName: g.GranteeName,
+		Role:        g.Role,
+		CreatedAt:   g.CreatedAt,
+	}
+}
+
+func writeGrants(w http.ResponseWriter, grants []*models.Grant) {
+	response := make([]GrantResponse, 0, len(grants))
+	for _, g := range grants {
+		response = append(response, toGrantResponse(g))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// saveGrant reads a GrantRequest and stores it for the file or folder set on
+// g, writing the response either way.
+func saveGrant(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int, g *models.Grant) {
+	var req GrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !models.IsGrantableRole(req.Role) {
+		http.Error(w, "Invalid role, expected view, download or edit", http.StatusBadRequest)
+		return
+	}
+
+	grantee, err := models.GetUserByEmail(db, strings.TrimSpace(req.Email))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if grantee.ID == userID {
+		http.Error(w, "You already own this", http.StatusBadRequest)
+		return
+	}
+
+	g.GranteeID = grantee.ID
+	g.GranteeEmail = grantee.Email
+	g.GranteeName = grantee.DisplayName
+	g.GrantedBy = userID
+	g.Role = req.Role
+	if err := g.Save(db); err != nil {
+		http.Error(w, "Failed to share", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toGrantResponse(g))
+}
+
+func grantIDFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	grantID, err := strconv.Atoi(mux.Vars(r)["grantID"])
+	if err != nil {
+		http.Error(w, "Invalid permission ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return grantID, true
+}
+
+func writeRevokeGrantResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Permission not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke permission", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GrantFileHandler shares one of the caller's files with another user.
+func GrantFileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file := ownedFileFromRequest(w, r, db, userID)
+		if file == nil {
+			return
+		}
+		saveGrant(w, r, db, userID, &models.Grant{FileID: &file.ID})
+	}
+}
+
+func ListFileGrantsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file := ownedFileFromRequest(w, r, db, userID)
+		if file == nil {
+			return
+		}
+
+		grants, err := models.GetFileGrants(db, file.ID)
+		if err != nil {
+			http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+			return
+		}
+		writeGrants(w, grants)
+	}
+}
+
+func RevokeFileGrantHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file := ownedFileFromRequest(w, r, db, userID)
+		if file == nil {
+			return
+		}
+		grantID, ok := grantIDFromRequest(w, r)
+		if !ok {
+			return
+		}
+		writeRevokeGrantResult(w, models.RevokeFileGrant(db, grantID, file.ID))
+	}
+}
+
+// GrantFolderHandler shares one of the caller's folders, and everything in
+// it now or later, with another user.
+func GrantFolderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+		saveGrant(w, r, db, userID, &models.Grant{FolderID: &folder.ID})
+	}
+}
+
+func ListFolderGrantsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+
+		grants, err := models.GetFolderGrants(db, folder.ID)
+		if err != nil {
+			http.Error(w, "Failed to get permissions", http.StatusInternalServerError)
+			return
+		}
+		writeGrants(w, grants)
+	}
+}
+
+func RevokeFolderGrantHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+		grantID, ok := grantIDFromRequest(w, r)
+		if !ok {
+			return
+		}
+		writeRevokeGrantResult(w, models.RevokeFolderGrant(db, grantID, folder.ID))
+	}
+}
+
+// SharedWithMeHandler lists the files and folders other users shared with
+// the caller directly. Contents of shared folders are listed with
+// GET /folders/{id}.
+func SharedWithMeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		files, err := models.GetFilesSharedWith(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get shared files", http.StatusInternalServerError)
+			return
+		}
+		folders, err := models.GetFoldersSharedWith(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get shared folders", http.StatusInternalServerError)
+			return
+		}
+
+		response := SharedWithMeResponse{
+			Files:   make([]SharedFileResponse, 0, len(files)),
+			Folders: make([]SharedFolderResponse, 0, len(folders)),
+		}
+		for _, f := range files {
+			response.Files = append(response.Files, SharedFileResponse{
+				FileResponse: toAccessibleFileResponse(f.File, f.Role),
+				Role:         f.Role,
+				OwnerEmail:   f.OwnerEmail,
+				OwnerName:    f.OwnerName,
+			})
+		}
+		for _, f := range folders {
+			response.Folders = append(response.Folders, SharedFolderResponse{
+				FolderResponse: toFolderResponse(f.Folder),
+				Role:           f.Role,
+				OwnerEmail:     f.OwnerEmail,
+				OwnerName:      f.OwnerName,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// loginFreeAttempts failed logins are allowed without delay, for typos.
+	loginFreeAttempts = 3
+	// loginMaxBackoff caps the delay between attempts short of a lockout.
+	loginMaxBackoff = time.Minute
+)
+
+// loginLimit is a count of failed logins, for one email address or one
+// client address. Failures are counted over LoginLockoutDuration from the
+// first; beyond loginFreeAttempts each makes the next attempt wait twice as
+// long, and reaching max locks logins for LoginLockoutDuration. The count
+// is kept for unknown emails too, so lockouts don't tell which accounts
+// exist.
+type loginLimit struct {
+	kind  string
+	id    string
+	max   int
+	event string
+}
+
+func loginLimits(cfg *config.Config, email, ip string) []loginLimit {
+	return []loginLimit{
+		{kind: "account", id: strings.ToLower(strings.TrimSpace(email)), max: cfg.LoginMaxAttempts, event: models.AuditLoginLockout},
+		{kind: "ip", id: ip, max: cfg.LoginIPMaxAttempts, event: models.AuditLoginIPLockout},
+	}
+}
+
+func (l loginLimit) failuresKey() string {
+	return "login_failures:" + l.kind + ":" + l.id
+}
+
+func (l loginLimit) blockedKey() string {
+	return "login_blocked:" + l.kind + ":" + l.id
+}
+
+// loginBackoff is how long to wait after the nth failed login.
+func loginBackoff(n int64) time.Duration {
+	if n <= loginFreeAttempts {
+		return 0
+	}
+	exp := float64(n - loginFreeAttempts - 1)
+	d := time.Duration(math.Pow(2, math.Min(exp, 30))) * time.Second
+	if d > loginMaxBackoff {
+		return loginMaxBackoff
+	}
+	return d
+}
+
+// loginBlockedFor returns how long logins under any of the limits have to
+// wait, zero if they may go ahead.
+func loginBlockedFor(ctx context.Context, rdb *redis.Client, limits []loginLimit) (time.Duration, error) {
+	cmds := make([]*redis.DurationCmd, len(limits))
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, l := range limits {
+			cmds[i] = pipe.PTTL(ctx, l.blockedKey())
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, cmd := range cmds {
+		if ttl := cmd.Val(); ttl > wait {
+			wait = ttl
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login under each limit and blocks
+// further attempts as the counts call for. Lockouts are written to the audit
+// log; userID is nil for unknown emails.
+func recordLoginFailure(r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, limits []loginLimit, userID *int, email string) error {
+	ctx := r.Context()
+	for _, l := range limits {
+		var incr *redis.IntCmd
+		_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.Incr(ctx, l.failuresKey())
+			pipe.ExpireNX(ctx, l.failuresKey(), cfg.LoginLockoutDuration)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		n := incr.Val()
+		if n < int64(l.max) {
+			if wait := loginBackoff(n); wait > 0 {
+				if err := rdb.Set(ctx, l.blockedKey(), "backoff", wait).Err(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Locked out: the count starts over once the lockout ends.
+		_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, l.blockedKey(), "lockout", cfg.LoginLockoutDuration)
+			pipe.Del(ctx, l.failuresKey())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Locked logins for %s %s after %d failed attempts", l.kind, l.id, n)
+		event := &models.AuditEvent{
+			Event:     l.event,
+			Email:     email,
+			IP:        auth.ClientIP(r, cfg),
+			UserAgent: r.UserAgent(),
+			Details: map[string]interface{}{
+				"attempts": n,
+				"duration": cfg.LoginLockoutDuration.String(),
+			},
+		}
+		if l.kind == "account" {
+			event.UserID = userID
+		}
+		if err := event.Create(db); err != nil {
+			log.Printf("Failed to record %s audit event: %v", l.event, err)
+		}
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failed logins of an account after its owner
+// logged in. Failures from the client address still count, so that knowing
+// one password doesn't help guessing others.
+func clearLoginFailures(ctx context.Context, rdb *redis.Client, limits []loginLimit) {
+	for _, l := range limits {
+		if l.kind != "account" {
+			continue
+		}
+		if err := rdb.Del(ctx, l.failuresKey(), l.blockedKey()).Err(); err != nil {
+			log.Printf("Failed to clear failed logins: %v", err)
+		}
+	}
+}
+
+// loginBlocked refuses the request if logins under any of the limits have
+// to wait, and returns whether it did.
+func loginBlocked(w http.ResponseWriter, r *http.Request, rdb *redis.Client, limits []loginLimit) bool {
+	wait, err := loginBlockedFor(r.Context(), rdb, limits)
+	if err != nil {
+		log.Printf("Failed to check login attempts: %v", err)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+	if wait > 0 {
+		refuseBlockedLogin(w, wait)
+		return true
+	}
+	return false
+}
+
+// refuseBlockedLogin answers a login attempt made while it has to wait.
+func refuseBlockedLogin(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed logins, please try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/gorilla/mux"
+)
+
+const notificationsLimit = 100
+
+// ListNotificationsHandler returns the caller's latest notifications;
+// ?unread=true leaves out the ones already read.
+func ListNotificationsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		unreadOnly := false
+		if v := r.URL.Query().Get("unread"); v != "" {
+			var err error
+			if unreadOnly, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "Invalid unread, expected true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		notifications, err := models.GetNotifications(db, userID, unreadOnly, notificationsLimit)
+		if err != nil {
+			http.Error(w, "Failed to get notifications", http.StatusInternalServerError)
+			return
+		}
+		if notifications == nil {
+			notifications = []*models.Notification{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifications)
+	}
+}
+
+func MarkNotificationReadHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		notificationID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.MarkNotificationRead(db, notificationID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Notification not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
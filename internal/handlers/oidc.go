@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// oidcLoginTTL is how long a user has to finish signing in at the identity
+// provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLogin is what is remembered of a login between sending the browser to
+// the identity provider and its return to the callback.
+type oidcLogin struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+// OIDCLoginHandler starts a single sign-on login by sending the browser to the
+// identity provider.
+func OIDCLoginHandler(rdb *redis.Client, provider *auth.OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := auth.GenerateRandomString(32)
+		login := oidcLogin{
+			Nonce:    auth.GenerateRandomString(32),
+			Verifier: auth.NewPKCEVerifier(),
+		}
+
+		redirectURL, err := provider.AuthCodeURL(ctx, state, login.Nonce, login.Verifier)
+		if err != nil {
+			log.Printf("Failed to reach identity provider: %v", err)
+			http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		data, _ := json.Marshal(login)
+		if err := rdb.Set(ctx, oidcStateKey(state), data, oidcLoginTTL).Err(); err != nil {
+			log.Printf("Failed to store login state: %v", err)
+			http.Error(w, "Failed to start login", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler finishes a single sign-on login: it redeems the
+// authorization code, finds or provisions the user and answers like /login.
+func OIDCCallbackHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet, provider *auth.OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		q := r.URL.Query()
+
+		if e := q.Get("error"); e != "" {
+			msg := "Login was refused by the identity provider: " + e
+			if desc := q.Get("error_description"); desc != "" {
+				msg += " (" + desc + ")"
+			}
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+
+		state, code := q.Get("state"), q.Get("code")
+		if state == "" || code == "" {
+			http.Error(w, "state and code are required", http.StatusBadRequest)
+			return
+		}
+
+		// Each state works once, so a callback URL can't be replayed.
+		var get *redis.StringCmd
+		_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			get = pipe.Get(ctx, oidcStateKey(state))
+			pipe.Del(ctx, oidcStateKey(state))
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.Printf("Failed to look up login state: %v", err)
+			http.Error(w, "Failed to complete login", http.StatusInternalServerError)
+			return
+		}
+		var login oidcLogin
+		if get.Err() != nil || json.Unmarshal([]byte(get.Val()), &login) != nil {
+			http.Error(w, "Login has expired or was already completed, please start again", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, code, login.Verifier, login.Nonce)
+		if err != nil {
+			log.Printf("Single sign-on login failed: %v", err)
+			http.Error(w, "Login with the identity provider failed", http.StatusUnauthorized)
+			return
+		}
+
+		userID, status, msg := oidcUser(db, provider.Issuer(), claims)
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		// Second factors are asked for here too, or signing in through the
+		// provider would get around them. Only enrollment can be left to a
+		// provider trusted to enforce its own.
+		twoFactor, err := models.GetTwoFactor(db, userID)
+		if err != nil {
+			log.Printf("Failed to get two-factor state of user %d: %v", userID, err)
+			http.Error(w, "Failed to complete login", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor.Enabled() || cfg.Require2FA && !cfg.OIDCTrustProvider2FA {
+			writeTwoFactorChallenge(w, r, rdb, userID, !twoFactor.Enabled())
+			return
+		}
+
+		issueTokens(w, r, db, cfg, keys, userID, "")
+	}
+}
+
+// oidcUser finds the user an ID token belongs to. Identities already seen
+// are found by subject. A new one is linked to the account with the same
+// email if the provider vouches for the email, and otherwise gets a new
+// account. On failure it returns the status and message to answer with.
+func oidcUser(db *sql.DB, issuer string, claims *auth.IDTokenClaims) (int, int, string) {
+	email := strings.TrimSpace(claims.Email)
+
+	identity, err := models.GetUserIdentity(db, issuer, claims.Subject)
+	if err == nil {
+		if err := models.TouchUserIdentity(db, identity.ID, email); err != nil {
+			log.Printf("Failed to update identity %d: %v", identity.ID, err)
+		}
+		return identity.UserID, 0, ""
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to look up identity: %v", err)
+		return 0, http.StatusInternalServerError, "Failed to complete login"
+	}
+
+	if email == "" {
+		return 0, http.StatusForbidden, "The identity provider did not share an email address"
+	}
+	identity = &models.UserIdentity{Issuer: issuer, Subject: claims.Subject, Email: email}
+
+	user, err := models.GetUserByEmail(db, email)
+	switch {
+	case err == nil:
+		// Linking on an unverified email would let anyone who can set their
+		// email at the provider take over the account.
+		if !claims.EmailVerified {
+			return 0, http.StatusConflict, "An account with this email already exists and the identity provider has not verified the email"
+		}
+		identity.UserID = user.ID
+		if err := identity.Create(db); err != nil {
+			log.Printf("Failed to link identity to user %d: %v", user.ID, err)
+			return 0, http.StatusInternalServerError, "Failed to complete login"
+		}
+		if err := models.SetEmailVerified(db, user.ID, email); err != nil {
+			log.Printf("Failed to mark email of user %d verified: %v", user.ID, err)
+		}
+		log.Printf("Linked identity %s of %s to user %d", claims.Subject, issuer, user.ID)
+		return user.ID, 0, ""
+	case errors.Is(err, sql.ErrNoRows):
+		user = &models.User{Email: email, DisplayName: strings.TrimSpace(claims.Name)}
+		if claims.EmailVerified {
+			now := time.Now().UTC()
+			user.EmailVerifiedAt = &now
+		}
+		if err := models.CreateUserWithIdentity(db, user, identity); err != nil {
+			log.Printf("Failed to provision user for identity %s: %v", claims.Subject, err)
+			return 0, http.StatusInternalServerError, "Failed to complete login"
+		}
+		return user.ID, 0, ""
+	default:
+		log.Printf("Failed to look up user by email: %v", err)
+		return 0, http.StatusInternalServerError, "Failed to complete login"
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// checkCurrentPassword makes the caller confirm an account change with their
+// password. Wrong guesses count as failed logins, so a stolen access token
+// doesn't allow unlimited guessing. On failure it answers the request and
+// returns false.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, user *models.User, password string) bool {
+	if user.PasswordHash == "" {
+		http.Error(w, "Your account has no password yet, set one with /password/forgot", http.StatusConflict)
+		return false
+	}
+	if password == "" {
+		http.Error(w, "Your current password is required", http.StatusBadRequest)
+		return false
+	}
+
+	limits := loginLimits(cfg, user.Email, auth.ClientIP(r, cfg))
+	if loginBlocked(w, r, rdb, limits) {
+		return false
+	}
+	if !auth.CheckPasswordHash(password, user.PasswordHash) {
+		if err := recordLoginFailure(r, db, rdb, cfg, limits, &user.ID, user.Email); err != nil {
+			log.Printf("Failed to record failed login: %v", err)
+		}
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	clearLoginFailures(r.Context(), rdb, limits)
+	return true
+}
+
+// ChangePasswordHandler sets a new password for the caller, who has to give
+// the current one. Other sessions are ended and outstanding reset links stop
+// working; the caller stays signed in.
+func ChangePasswordHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, policy *auth.PasswordPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PasswordLoginEnabled {
+			refusePasswordLogin(w)
+			return
+		}
+		userID := r.Context().Value("userID").(int)
+
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.CurrentPassword == "" || req.NewPassword == "" {
+			http.Error(w, "current_password and new_password are required", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+		if !checkCurrentPassword(w, r, db, rdb, cfg, user, req.CurrentPassword) {
+			return
+		}
+
+		if err := policy.Check(req.NewPassword, user.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		passwordHash, err := auth.HashPasswordCost(req.NewPassword, cfg.BcryptCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+		if err := models.SetPasswordHash(db, user.ID, passwordHash); err != nil {
+			log.Printf("Failed to set password of user %d: %v", user.ID, err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+
+		if err := models.InvalidateUserTokens(db, user.ID, models.TokenPurposeResetPassword); err != nil {
+			log.Printf("Failed to invalidate reset links of user %d: %v", user.ID, err)
+		}
+		keep, _ := r.Context().Value("sessionID").(string)
+		if _, err := endSessions(r.Context(), db, rdb, cfg, user.ID, nil, keep); err != nil {
+			log.Printf("Failed to end sessions of user %d after password change: %v", user.ID, err)
+		}
+		event := &models.AuditEvent{
+			UserID:    &user.ID,
+			Event:     models.AuditPasswordChange,
+			Email:     user.Email,
+			IP:        auth.ClientIP(r, cfg),
+			UserAgent: r.UserAgent(),
+		}
+		if err := event.Create(db); err != nil {
+			log.Printf("Failed to record %s audit event: %v", event.Event, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
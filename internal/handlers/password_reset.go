@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/mail"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type passwordResetPage struct {
+	Token string
+	Error string
+}
+
+var errInvalidResetToken = errors.New("This reset link is invalid, was already used or has expired")
+
+// ForgotPasswordHandler mails a password reset link. It answers the same
+// whether or not there is an account with the address, so it can't be used
+// to find out who has one.
+func ForgotPasswordHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PasswordLoginEnabled {
+			refusePasswordLogin(w)
+			return
+		}
+
+		var req EmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByEmail(db, email)
+		if err == nil {
+			if err := sendUserToken(r.Context(), db, rdb, cfg, mailer, user, models.TokenPurposeResetPassword, user.Email); err != nil {
+				log.Printf("Failed to send password reset to user %d: %v", user.ID, err)
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up user by email: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// resetPassword sets a new password with a reset token. The token is only
+// used up once the password passes the policy, so the user can try another.
+// Since following the link proves the user reads the mailbox, the address
+// counts as verified. Every session is ended, in case the reset is because
+// someone else got in.
+func resetPassword(r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, policy *auth.PasswordPolicy, req ResetPasswordRequest) (int, error) {
+	if req.Password == "" {
+		return http.StatusBadRequest, errors.New("Password is required")
+	}
+
+	tokenHash := auth.HashToken(req.Token)
+	stored, err := models.GetUserToken(db, models.TokenPurposeResetPassword, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusBadRequest, errInvalidResetToken
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	user, err := models.GetUserByID(db, stored.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if user.Email != stored.Email {
+		return http.StatusBadRequest, errInvalidResetToken
+	}
+	if err := policy.Check(req.Password, user.Email); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	passwordHash, err := auth.HashPasswordCost(req.Password, cfg.BcryptCost)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	// Another request may have used the token in the meantime.
+	_, err = models.UseUserToken(db, models.TokenPurposeResetPassword, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusBadRequest, errInvalidResetToken
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := models.SetPasswordHash(db, user.ID, passwordHash); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := models.InvalidateUserTokens(db, user.ID, models.TokenPurposeResetPassword); err != nil {
+		log.Printf("Failed to invalidate reset links of user %d: %v", user.ID, err)
+	}
+	if err := models.SetEmailVerified(db, user.ID, stored.Email); err != nil {
+		log.Printf("Failed to mark email of user %d verified: %v", user.ID, err)
+	}
+	if _, err := endSessions(r.Context(), db, rdb, cfg, user.ID, nil, ""); err != nil {
+		log.Printf("Failed to end sessions of user %d after password reset: %v", user.ID, err)
+	}
+	return http.StatusOK, nil
+}
+
+// ResetPasswordPageHandler is where the link in a reset email leads: a form
+// for the new password.
+func ResetPasswordPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		renderPage(w, http.StatusOK, "password_reset.html", passwordResetPage{Token: r.URL.Query().Get("token")})
+	}
+}
+
+// ResetPasswordHandler sets a new password with a token from a reset email.
+// It takes JSON from API clients, answering 204, or the form of the reset
+// page, answering with a page.
+func ResetPasswordHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, policy *auth.PasswordPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.PasswordLoginEnabled {
+			refusePasswordLogin(w)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		isForm := mediaType == "application/x-www-form-urlencoded"
+
+		var req ResetPasswordRequest
+		if isForm {
+			req.Token = r.PostFormValue("token")
+			req.Password = r.PostFormValue("password")
+			if req.Password != r.PostFormValue("confirm_password") {
+				renderPage(w, http.StatusBadRequest, "password_reset.html", passwordResetPage{
+					Token: req.Token,
+					Error: "The passwords don't match.",
+				})
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		status, err := resetPassword(r, db, rdb, cfg, policy, req)
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to reset password: %v", err)
+			http.Error(w, "Failed to reset password", status)
+			return
+		}
+
+		if isForm {
+			if err != nil {
+				page := passwordResetPage{Token: req.Token, Error: err.Error() + "."}
+				if err == errInvalidResetToken {
+					page.Token = ""
+				}
+				renderPage(w, status, "password_reset.html", page)
+				return
+			}
+			renderPage(w, http.StatusOK, "account_message.html", accountMessagePage{
+				Title:   "Password changed",
+				Message: "Your password has been changed and you have been signed out everywhere. You can now log in with the new password.",
+			})
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/mail"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// localePattern accepts BCP 47 language tags such as "en", "pt-BR" or
+// "zh-Hant-TW".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// ProfileResponse is the caller's account. PendingEmail is an address the
+// user asked to change to and hasn't confirmed yet.
+type ProfileResponse struct {
+	*models.User
+	HasPassword  bool   `json:"has_password"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// UpdateProfileRequest changes profile settings. Fields left out are kept;
+// empty strings clear them.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// DeleteAccountRequest confirms an account deletion: with the password, or
+// for accounts without one, by repeating the email address.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+func writeProfile(w http.ResponseWriter, db *sql.DB, user *models.User) {
+	response := ProfileResponse{User: user, HasPassword: user.PasswordHash != ""}
+	pending, err := models.GetPendingEmailChange(db, user.ID)
+	if err == nil {
+		response.PendingEmail = pending.Email
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to look up email change of user %d: %v", user.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// applyProfileUpdate checks the changes in req and makes them to user.
+func applyProfileUpdate(user *models.User, req UpdateProfileRequest) error {
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > 255 {
+			return errors.New("display_name must be at most 255 characters")
+		}
+		user.DisplayName = name
+	}
+	if req.AvatarURL != nil {
+		avatar := strings.TrimSpace(*req.AvatarURL)
+		if avatar != "" {
+			u, err := url.Parse(avatar)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(avatar) > 512 {
+				return errors.New("avatar_url must be an http or https URL of at most 512 characters")
+			}
+		}
+		user.AvatarURL = avatar
+	}
+	if req.Locale != nil {
+		locale := strings.TrimSpace(*req.Locale)
+		if locale != "" && (len(locale) > 35 || !localePattern.MatchString(locale)) {
+			return errors.New("locale must be a language tag such as en or pt-BR")
+		}
+		user.Locale = locale
+	}
+	if req.Timezone != nil {
+		tz := strings.TrimSpace(*req.Timezone)
+		if tz != "" {
+			if _, err := time.LoadLocation(tz); err != nil || tz == "Local" || len(tz) > 64 {
+				return errors.New("timezone must be an IANA time zone such as Europe/Berlin")
+			}
+		}
+		user.Timezone = tz
+	}
+	return nil
+}
+
+// GetProfileHandler returns the caller's account.
+func GetProfileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Failed to get profile", http.StatusInternalServerError)
+			return
+		}
+		writeProfile(w, db, user)
+	}
+}
+
+// UpdateProfileHandler changes the caller's display name, avatar, locale and
+// time zone. The email address is changed through /me/email.
+func UpdateProfileHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req UpdateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		if err := applyProfileUpdate(user, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := models.UpdateProfile(db, user); err != nil {
+			log.Printf("Failed to update profile of user %d: %v", userID, err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		writeProfile(w, db, user)
+	}
+}
+
+// ChangeEmailHandler starts changing the caller's email address: it mails a
+// confirmation link to the new address, and the account keeps the old one
+// until the link is followed.
+func ChangeEmailHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req ChangeEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Failed to change email", http.StatusInternalServerError)
+			return
+		}
+		if !checkCurrentPassword(w, r, db, rdb, cfg, user, req.Password) {
+			return
+		}
+		if strings.EqualFold(email, user.Email) {
+			http.Error(w, "This is already your email address", http.StatusBadRequest)
+			return
+		}
+
+		_, err = models.GetUserByEmail(db, email)
+		if err == nil {
+			http.Error(w, "An account with this email already exists", http.StatusConflict)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up user by email: %v", err)
+			http.Error(w, "Failed to change email", http.StatusInternalServerError)
+			return
+		}
+
+		if err := sendUserToken(r.Context(), db, rdb, cfg, mailer, user, models.TokenPurposeChangeEmail, email); err != nil {
+			log.Printf("Failed to send email change to user %d: %v", userID, err)
+			http.Error(w, "Failed to change email", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// DeleteAccountHandler deletes the caller's account, everything it owns and
+// the stored contents of its files. Its sessions end at once.
+func DeleteAccountHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		if user.PasswordHash != "" {
+			if !checkCurrentPassword(w, r, db, rdb, cfg, user, req.Password) {
+				return
+			}
+		} else if !strings.EqualFold(strings.TrimSpace(req.Email), user.Email) {
+			http.Error(w, "Repeat your email address to confirm", http.StatusBadRequest)
+			return
+		}
+
+		// Drop links stop taking files, and sessions are ended while they
+		// still exist to deny their access tokens, before the files are
+		// collected.
+		if err := models.RevokeUploadRequestsByUser(db, userID); err != nil {
+			log.Printf("Failed to revoke upload requests of user %d: %v", userID, err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		if _, err := endSessions(r.Context(), db, rdb, cfg, userID, nil, ""); err != nil {
+			log.Printf("Failed to end sessions of user %d: %v", userID, err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		fileURLs, err := models.DeleteUser(db, userID)
+		if err != nil {
+			log.Printf("Failed to delete user %d: %v", userID, err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+
+		event := &models.AuditEvent{
+			Event:     models.AuditAccountDelete,
+			Email:     user.Email,
+			IP:        auth.ClientIP(r, cfg),
+			UserAgent: r.UserAgent(),
+			Details: map[string]interface{}{
+				"user_id": userID,
+				"files":   len(fileURLs),
+			},
+		}
+		if err := event.Create(db); err != nil {
+			log.Printf("Failed to record %s audit event: %v", event.Event, err)
+		}
+
+		// The rows are gone, so the contents can't be reached any more;
+		// removing them can take a while and doesn't hold up the answer.
+		go func() {
+			failed := 0
+			for _, fileURL := range fileURLs {
+				if err := storage.Delete(fileURL); err != nil {
+					log.Printf("Failed to delete %s of deleted user %d: %v", fileURL, userID, err)
+					failed++
+				}
+			}
+			log.Printf("Deleted user %d and %d of %d stored files", userID, len(fileURLs)-failed, len(fileURLs))
+		}()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
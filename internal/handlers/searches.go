@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type SavedSearchRequest struct {
+	Name  string                  `json:"name"`
+	Query models.SavedSearchQuery `json:"query"`
+}
+
+type SavedSearchResponse struct {
+	ID        int                     `json:"id"`
+	Name      string                  `json:"name"`
+	Query     models.SavedSearchQuery `json:"query"`
+	URL       string                  `json:"url"`
+	CreatedAt time.Time               `json:"created_at"`
+	UpdatedAt time.Time               `json:"updated_at"`
+}
+
+// SmartFolderResponse is how a saved search appears alongside files in the
+// listing; URL returns its contents.
+type SmartFolderResponse struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func savedSearchURL(id int) string {
+	return fmt.Sprintf("/searches/%d/files", id)
+}
+
+func toSavedSearchResponse(s *models.SavedSearch) SavedSearchResponse {
+	return SavedSearchResponse{
+		ID:        s.ID,
+		Name:      s.Name,
+		Query:     s.Query,
+		URL:       savedSearchURL(s.ID),
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+}
+
+func toSmartFolderResponse(s *models.SavedSearch) SmartFolderResponse {
+	return SmartFolderResponse{ID: s.ID, Name: s.Name, URL: savedSearchURL(s.ID)}
+}
+
+// savedQueryValues turns a saved definition back into the query parameters
+// of /files or /files/search, so it is run by exactly the same code.
+func savedQueryValues(sq models.SavedSearchQuery) url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("q", sq.Q)
+	set("mode", sq.Mode)
+	set("type", sq.Type)
+	for _, t := range sq.Tags {
+		v.Add("tag", t)
+	}
+	if sq.MinSize > 0 {
+		v.Set("min_size", strconv.FormatInt(sq.MinSize, 10))
+	}
+	if sq.MaxSize > 0 {
+		v.Set("max_size", strconv.FormatInt(sq.MaxSize, 10))
+	}
+	set("created_after", sq.CreatedAfter)
+	set("created_before", sq.CreatedBefore)
+	set("created_within", sq.CreatedWithin)
+	set("sort", sq.Sort)
+	set("order", sq.Order)
+	return v
+}
+
+func validateSavedSearchRequest(req *SavedSearchRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	req.Query.Tags = parseTags(strings.Join(req.Query.Tags, ","))
+
+	q := savedQueryValues(req.Query)
+	if req.Query.Q == "" {
+		if req.Query.Mode != "" {
+			return errors.New("mode requires q")
+		}
+		_, err := parseFileListOptions(q)
+		return err
+	}
+
+	if req.Query.Sort != "" || req.Query.Order != "" {
+		return errors.New("search results are ordered by relevance, sort and order require an empty q")
+	}
+	switch req.Query.Mode {
+	case "", "fulltext", "fuzzy":
+	default:
+		return errors.New("invalid mode, expected fulltext or fuzzy")
+	}
+	_, err := parseFileFilter(q)
+	return err
+}
+
+func decodeSavedSearchRequest(r *http.Request) (*SavedSearchRequest, error) {
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.New("Invalid request body")
+	}
+	if err := validateSavedSearchRequest(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func CreateSavedSearchHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		req, err := decodeSavedSearchRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		search := &models.SavedSearch{UserID: userID, Name: req.Name, Query: req.Query}
+		if err := search.Create(db); err != nil {
+			if errors.Is(err, models.ErrDuplicateName) {
+				http.Error(w, "A saved search with this name already exists", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to save search", http.StatusInternalServerError)
+			return
+		}
+		invalidateFileListCache(context.Background(), rdb, userID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(toSavedSearchResponse(search))
+	}
+}
+
+func ListSavedSearchesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		searches, err := models.GetSavedSearchesByUser(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get saved searches", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]SavedSearchResponse, 0, len(searches))
+		for _, s := range searches {
+			response = append(response, toSavedSearchResponse(s))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// savedSearchFromRequest loads the saved search named by the {id} route
+// variable, writing an error response and returning nil if it can't.
+func savedSearchFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int) *models.SavedSearch {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return nil
+	}
+
+	search, err := models.GetSavedSearchByID(db, id, userID)
+	if err != nil {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return nil
+	}
+	return search
+}
+
+func GetSavedSearchHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		search := savedSearchFromRequest(w, r, db, userID)
+		if search == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toSavedSearchResponse(search))
+	}
+}
+
+func UpdateSavedSearchHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		search := savedSearchFromRequest(w, r, db, userID)
+		if search == nil {
+			return
+		}
+
+		req, err := decodeSavedSearchRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		search.Name = req.Name
+		search.Query = req.Query
+		if err := search.Update(db); err != nil {
+			if errors.Is(err, models.ErrDuplicateName) {
+				http.Error(w, "A saved search with this name already exists", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to update saved search", http.StatusInternalServerError)
+			return
+		}
+		invalidateFileListCache(context.Background(), rdb, userID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toSavedSearchResponse(search))
+	}
+}
+
+func DeleteSavedSearchHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.DeleteSavedSearch(db, id, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Saved search not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete saved search", http.StatusInternalServerError)
+			return
+		}
+		invalidateFileListCache(context.Background(), rdb, userID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RunSavedSearchHandler returns the contents of a smart folder. Definitions
+// with a text query answer like /files/search, the others like /files, with
+// limit and cursor taken from the request.
+func RunSavedSearchHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		search := savedSearchFromRequest(w, r, db, userID)
+		if search == nil {
+			return
+		}
+
+		q := savedQueryValues(search.Query)
+		for _, key := range []string{"limit", "cursor"} {
+			if v := r.URL.Query().Get(key); v != "" {
+				q.Set(key, v)
+			}
+		}
+
+		if search.Query.Q != "" {
+			writeSearchResults(w, db, userID, q)
+			return
+		}
+		writeFileList(w, db, rdb, userID, q, fmt.Sprintf("saved:%d", search.ID))
+	}
+}
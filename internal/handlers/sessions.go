@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// describeUserAgent turns a User-Agent header into a short label such as
+// "Firefox on Linux", good enough to recognise one's own devices.
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			platform = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	if i := strings.IndexAny(ua, " /"); i > 0 {
+		return ua[:i]
+	}
+	return ua
+}
+
+// endSessions revokes sessions of a user as models.RevokeSessions does and
+// denies the access tokens already issued for them. It returns the IDs of the
+// sessions that were still active.
+func endSessions(ctx context.Context, db *sql.DB, rdb *redis.Client, cfg *config.Config, userID int, sessionIDs []string, keep string) ([]string, error) {
+	revoked, err := models.RevokeSessions(db, userID, sessionIDs, keep)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range revoked {
+		if err := auth.RevokeSessionTokens(ctx, rdb, id, cfg.JWTExpiration); err != nil {
+			return nil, err
+		}
+	}
+	return revoked, nil
+}
+
+// ListSessionsHandler lists the devices the caller is signed in on.
+func ListSessionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		currentID, _ := r.Context().Value("sessionID").(string)
+
+		sessions, err := models.GetActiveSessions(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]SessionResponse, 0, len(sessions))
+		for _, s := range sessions {
+			response = append(response, SessionResponse{
+				ID:         s.ID,
+				Device:     describeUserAgent(s.UserAgent),
+				UserAgent:  s.UserAgent,
+				IP:         s.IP,
+				CreatedAt:  s.CreatedAt,
+				LastSeenAt: s.LastSeenAt,
+				Current:    s.ID == currentID,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// RevokeSessionHandler signs the caller out on one device, which may be the
+// current one.
+func RevokeSessionHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		sessionID := mux.Vars(r)["id"]
+
+		revoked, err := endSessions(r.Context(), db, rdb, cfg, userID, []string{sessionID}, "")
+		if err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionID, err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+		if len(revoked) == 0 {
+			http.Error(w, "Session not found or already revoked", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeSessionsHandler signs the caller out everywhere, or with
+// ?keep_current=true everywhere else.
+func RevokeSessionsHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		keep := ""
+		if v := r.URL.Query().Get("keep_current"); v != "" {
+			keepCurrent, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Invalid keep_current, expected true or false", http.StatusBadRequest)
+				return
+			}
+			if keepCurrent {
+				keep, _ = r.Context().Value("sessionID").(string)
+			}
+		}
+
+		if _, err := endSessions(r.Context(), db, rdb, cfg, userID, nil, keep); err != nil {
+			log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,550 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+const (
+	shareUnlockTTL         = 15 * time.Minute
+	shareUnlockMaxAttempts = 5
+	shareUnlockWindow      = 15 * time.Minute
+	shareDownloadGrantTTL  = 30 * time.Minute
+)
+
+type shareUnlockPage struct {
+	Token string
+	Error string
+}
+
+func shareUnlockCookieName(share *models.Share) string {
+	return fmt.Sprintf("share_unlock_%d", share.ID)
+}
+
+func shareUnlockKey(share *models.Share, value string) string {
+	return fmt.Sprintf("share_unlock:%d:%s", share.ID, value)
+}
+
+// isShareUnlocked reports whether the request may access the share: either it
+// has no password, or the request carries a cookie from a successful unlock.
+// The unlock stores the password hash it was made against, so changing the
+// password locks everyone out again.
+func isShareUnlocked(ctx context.Context, r *http.Request, rdb *redis.Client, share *models.Share) bool {
+	if share.PasswordHash == "" {
+		return true
+	}
+	cookie, err := r.Cookie(shareUnlockCookieName(share))
+	if err != nil {
+		return false
+	}
+	hash, err := rdb.Get(ctx, shareUnlockKey(share, cookie.Value)).Result()
+	return err == nil && hash == share.PasswordHash
+}
+
+// countUnlockAttempt counts a password attempt under key and reports whether
+// it is within shareUnlockMaxAttempts for the current window, setting
+// Retry-After when it isn't. Callers DECR the key after a correct password so
+// that legitimate users don't use up the allowance.
+func countUnlockAttempt(ctx context.Context, w http.ResponseWriter, rdb *redis.Client, key string) (bool, error) {
+	var incr *redis.IntCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, shareUnlockWindow)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if incr.Val() > shareUnlockMaxAttempts {
+		if ttl, err := rdb.TTL(ctx, key).Result(); err == nil && ttl > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())+1))
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// UnlockShareHandler checks the password for a protected link and, on
+// success, sets a short-lived cookie and sends the browser back to the link.
+// Attempts are limited per link, not per client, since an attacker can
+// rotate addresses far more cheaply than they can guess passwords.
+func UnlockShareHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		token := mux.Vars(r)["token"]
+
+		share, err := models.GetActiveShare(db, token)
+		if err != nil {
+			http.Error(w, "File not found or expired", http.StatusNotFound)
+			return
+		}
+		shareURL := "/share/" + token
+		if share.PasswordHash == "" {
+			http.Redirect(w, r, shareURL, http.StatusSeeOther)
+			return
+		}
+
+		attemptsKey := fmt.Sprintf("share_unlock_attempts:%d", share.ID)
+		allowed, err := countUnlockAttempt(ctx, w, rdb, attemptsKey)
+		if err != nil {
+			log.Printf("Failed to count unlock attempt for share %d: %v", share.ID, err)
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !allowed {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionUnlock, models.ShareOutcomeThrottled, 0)
+			renderPage(w, http.StatusTooManyRequests, "share_unlock.html", shareUnlockPage{
+				Token: token,
+				Error: "Too many attempts. Please try again later.",
+			})
+			return
+		}
+
+		if !auth.CheckPasswordHash(r.FormValue("password"), share.PasswordHash) {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionUnlock, models.ShareOutcomeDenied, 0)
+			renderPage(w, http.StatusUnauthorized, "share_unlock.html", shareUnlockPage{
+				Token: token,
+				Error: "Incorrect password.",
+			})
+			return
+		}
+		rdb.Decr(ctx, attemptsKey)
+
+		value := auth.GenerateRandomString(32)
+		if err := rdb.Set(ctx, shareUnlockKey(share, value), share.PasswordHash, shareUnlockTTL).Err(); err != nil {
+			http.Error(w, "Failed to unlock share", http.StatusInternalServerError)
+			return
+		}
+
+		logShareAccess(db, cfg, r, share, nil, models.ShareActionUnlock, models.ShareOutcomeOK, 0)
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareUnlockCookieName(share),
+			Value:    value,
+			Path:     shareURL,
+			MaxAge:   int(shareUnlockTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(cfg.ServerBaseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, shareURL, http.StatusSeeOther)
+	}
+}
+
+// isNewDownload tells a fresh fetch of a file from the follow-up range
+// requests a video player or download manager makes, so that only the first
+// one counts in the statistics of links without a download limit. Clients
+// choose their headers, so limited links count every request instead, see
+// downloadGrant.
+func isNewDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// isRangeContinuation reports whether a request asks for one byte range that
+// starts past the beginning of the file, as when resuming a download.
+// Suffix ranges such as bytes=-500 and ranges from 0 can return the whole
+// file, so they don't qualify.
+func isRangeContinuation(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return err == nil && n > 0
+}
+
+func shareDownloadCookieName(share *models.Share) string {
+	return fmt.Sprintf("share_download_%d", share.ID)
+}
+
+func shareDownloadKey(share *models.Share, value string) string {
+	return fmt.Sprintf("share_download:%d:%s", share.ID, value)
+}
+
+// downloadGrant returns the file whose counted download the request may
+// continue on a link with a download limit, from the cookie grantDownload
+// set.
+func downloadGrant(ctx context.Context, r *http.Request, rdb *redis.Client, share *models.Share) (int, bool) {
+	cookie, err := r.Cookie(shareDownloadCookieName(share))
+	if err != nil {
+		return 0, false
+	}
+	fileID, err := rdb.Get(ctx, shareDownloadKey(share, cookie.Value)).Int()
+	return fileID, err == nil
+}
+
+// grantDownload lets the client fetch the rest of a download that was just
+// counted with range requests for shareDownloadGrantTTL, without counting
+// them again. One grant is kept per link and client, for the latest file.
+func grantDownload(ctx context.Context, w http.ResponseWriter, rdb *redis.Client, cfg *config.Config, share *models.Share, file *models.File) {
+	value := auth.GenerateRandomString(32)
+	if err := rdb.Set(ctx, shareDownloadKey(share, value), file.ID, shareDownloadGrantTTL).Err(); err != nil {
+		log.Printf("Failed to store download grant for share %d: %v", share.ID, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareDownloadCookieName(share),
+		Value:    value,
+		Path:     "/share/" + share.Token,
+		MaxAge:   int(shareDownloadGrantTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.ServerBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+const maxTextPreviewSize = 5 << 20
+
+// Only formats browsers render without running anything are previewed. SVG
+// is left out because it can carry scripts.
+var previewImageTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true,
+	"image/webp": true, "image/avif": true, "image/bmp": true,
+}
+
+// previewKind decides how the landing page shows a file: "image", "video",
+// "audio", "pdf", "text", or "" for no preview.
+func previewKind(f *models.File) string {
+	mediaType, _, err := mime.ParseMediaType(f.Type)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case previewImageTypes[mediaType]:
+		return "image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "audio"
+	case mediaType == "application/pdf":
+		return "pdf"
+	case (strings.HasPrefix(mediaType, "text/") || mediaType == "application/json") && f.Size <= maxTextPreviewSize:
+		return "text"
+	}
+	return ""
+}
+
+// previewContentType is the type a preview is served as. Text of any kind is
+// sent as plain text so that shared HTML can't run on this origin.
+func previewContentType(kind string, f *models.File) string {
+	if kind == "text" {
+		return "text/plain; charset=utf-8"
+	}
+	return f.Type
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+type shareUnavailablePage struct {
+	Message string
+}
+
+type sharePage struct {
+	Token              string
+	Name               string
+	Size               string
+	Type               string
+	Uploader           string
+	ExpiresAt          string
+	LimitedDownloads   bool
+	RemainingDownloads int
+	Preview            string
+	CanDownload        bool
+}
+
+// countingResponseWriter records the status and body size of a response for
+// the share access log.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (c *countingResponseWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingResponseWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(b)
+	c.bytes += int64(n)
+	return n, err
+}
+
+// logShareAccess appends a request to the share's access log. file is the
+// file the request was about, nil for requests that concern a whole folder or
+// selection. Failing to log never fails the request itself.
+func logShareAccess(db *sql.DB, cfg *config.Config, r *http.Request, share *models.Share, file *models.File, action, outcome string, bytes int64) {
+	fileID := share.FileID
+	if file != nil {
+		fileID = &file.ID
+	}
+	access := &models.ShareAccess{
+		ShareID:     share.ID,
+		FileID:      fileID,
+		Action:      action,
+		Outcome:     outcome,
+		IP:          auth.ClientIP(r, cfg),
+		UserAgent:   r.UserAgent(),
+		BytesServed: bytes,
+	}
+	if err := access.Create(db); err != nil {
+		log.Printf("Failed to log access to share %d: %v", share.ID, err)
+	}
+}
+
+// serveSharedFile is serveFileContent for share links, logging how much of
+// the file was sent.
+func serveSharedFile(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, storage storage.Storage, share *models.Share, file *models.File, action, disposition, contentType string) {
+	cw := &countingResponseWriter{ResponseWriter: w}
+	serveFileContent(cw, r, storage, file, disposition, contentType)
+
+	outcome := models.ShareOutcomeOK
+	if cw.status >= http.StatusBadRequest {
+		outcome = models.ShareOutcomeError
+	}
+	logShareAccess(db, cfg, r, share, file, action, outcome, cw.bytes)
+}
+
+// shareFromRequest resolves the {token} route variable to a usable share.
+// Otherwise it writes the page explaining why - unknown, revoked, expired or
+// used-up link, or the unlock form - logs the refused action and returns nil.
+func shareFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, action string) *models.Share {
+	token := mux.Vars(r)["token"]
+
+	share, err := models.GetShareByToken(db, token)
+	if err != nil {
+		renderPage(w, http.StatusNotFound, "share_unavailable.html",
+			shareUnavailablePage{Message: "This link doesn't exist. Check that it was copied completely."})
+		return nil
+	}
+
+	status := share.Status(time.Now())
+	// The last counted download can still be finished once the limit is
+	// reached; ShareDownloadHandler refuses anything else.
+	if status == models.ShareStatusExhausted && action == models.ShareActionDownload {
+		if _, ok := downloadGrant(r.Context(), r, rdb, share); ok {
+			status = models.ShareStatusActive
+		}
+	}
+	if status != models.ShareStatusActive {
+		logShareAccess(db, cfg, r, share, nil, action, status, 0)
+	}
+	switch status {
+	case models.ShareStatusRevoked:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This link has been disabled by its owner."})
+		return nil
+	case models.ShareStatusExpired:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This link has expired."})
+		return nil
+	case models.ShareStatusExhausted:
+		renderPage(w, http.StatusGone, "share_unavailable.html",
+			shareUnavailablePage{Message: "This link has reached its download limit."})
+		return nil
+	}
+
+	if !isShareUnlocked(r.Context(), r, rdb, share) {
+		logShareAccess(db, cfg, r, share, nil, action, models.ShareOutcomeLocked, 0)
+		renderPage(w, http.StatusUnauthorized, "share_unlock.html", shareUnlockPage{Token: token})
+		return nil
+	}
+	return share
+}
+
+// sharedFileFromRequest is shareFromRequest followed by resolving the file
+// asked for: the {fileID} route variable, which must be part of the share, or
+// the shared file itself for single-file links. It returns nils after writing
+// a response if either step fails.
+func sharedFileFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, action string) (*models.Share, *models.File) {
+	share := shareFromRequest(w, r, db, rdb, cfg, action)
+	if share == nil {
+		return nil, nil
+	}
+
+	var entry *models.FileEntry
+	err := sql.ErrNoRows
+	if v, ok := mux.Vars(r)["fileID"]; ok {
+		if fileID, convErr := strconv.Atoi(v); convErr == nil {
+			entry, err = models.GetShareEntry(db, share, fileID)
+		}
+	} else if share.FileID != nil {
+		entry, err = models.GetShareEntry(db, share, *share.FileID)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to resolve file of share %d: %v", share.ID, err)
+		}
+		logShareAccess(db, cfg, r, share, nil, action, models.ShareOutcomeDenied, 0)
+		renderPage(w, http.StatusNotFound, "share_unavailable.html",
+			shareUnavailablePage{Message: "This file is not part of the link, or has been deleted."})
+		return nil, nil
+	}
+	return share, entry.File
+}
+
+// SharePageHandler shows recipients what they were sent - name, size, type,
+// who shared it and until when - with an inline preview where the browser can
+// display the file, instead of starting a download straight away.
+func SharePageHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share := shareFromRequest(w, r, db, rdb, cfg, models.ShareActionPage)
+		if share == nil {
+			return
+		}
+		if share.Kind() != models.ShareKindFile {
+			renderShareCollection(w, r, db, cfg, share)
+			return
+		}
+		file, err := models.GetFileByID(db, *share.FileID, share.CreatedBy)
+		if err != nil {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionPage, models.ShareOutcomeError, 0)
+			renderPage(w, http.StatusNotFound, "share_unavailable.html",
+				shareUnavailablePage{Message: "The shared file has been deleted."})
+			return
+		}
+
+		page := sharePage{
+			Token:       share.Token,
+			Name:        file.Name,
+			Size:        formatSize(file.Size),
+			Type:        file.Type,
+			CanDownload: share.Permission == models.SharePermissionDownload,
+		}
+		if remaining := share.RemainingDownloads(); remaining != nil {
+			page.LimitedDownloads = true
+			page.RemainingDownloads = *remaining
+		}
+		if share.ExpiresAt != nil {
+			page.ExpiresAt = share.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST")
+		}
+		// A preview would hand out the content without counting a download,
+		// so links with a download limit only offer the download.
+		if share.MaxDownloads == nil {
+			page.Preview = previewKind(file)
+		}
+		if owner, err := models.GetUserByID(db, file.UserID); err == nil {
+			page.Uploader = owner.DisplayName
+		}
+
+		recordAccess(db, file.ID, 0, models.AccessView)
+		logShareAccess(db, cfg, r, share, file, models.ShareActionPage, models.ShareOutcomeOK, 0)
+		renderPage(w, http.StatusOK, "share.html", page)
+	}
+}
+
+// SharePreviewHandler serves a shared file inline for the landing page's
+// preview.
+func SharePreviewHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, file := sharedFileFromRequest(w, r, db, rdb, cfg, models.ShareActionPreview)
+		if share == nil {
+			return
+		}
+
+		kind := previewKind(file)
+		if kind == "" || share.MaxDownloads != nil {
+			logShareAccess(db, cfg, r, share, file, models.ShareActionPreview, models.ShareOutcomeDenied, 0)
+			http.Error(w, "Preview not available", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		serveSharedFile(w, r, db, cfg, storage, share, file, models.ShareActionPreview, "inline", previewContentType(kind, file))
+	}
+}
+
+// ShareDownloadHandler sends a shared file as an attachment. Links with a
+// download limit answer 410 Gone once it is used up; for folder and selection
+// links every file downloaded counts. On limited links every request counts,
+// except range requests continuing a counted download, see grantDownload.
+// Those are only honoured where storage can serve ranges, since otherwise
+// each would send the whole file.
+func ShareDownloadHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, file := sharedFileFromRequest(w, r, db, rdb, cfg, models.ShareActionDownload)
+		if share == nil {
+			return
+		}
+
+		if share.Permission != models.SharePermissionDownload {
+			logShareAccess(db, cfg, r, share, file, models.ShareActionDownload, models.ShareOutcomeDenied, 0)
+			http.Error(w, "This link only allows viewing the file", http.StatusForbidden)
+			return
+		}
+
+		ctx := r.Context()
+		limited := share.MaxDownloads != nil
+		if limited && storage.SupportsRanges() && isRangeContinuation(r) {
+			if fileID, ok := downloadGrant(ctx, r, rdb, share); ok && fileID == file.ID {
+				// A mismatched If-Range would make ServeContent send the
+				// whole file; stored contents never change anyway.
+				r.Header.Del("If-Range")
+				serveSharedFile(w, r, db, cfg, storage, share, file, models.ShareActionDownload, "attachment", "")
+				return
+			}
+		}
+
+		if limited || isNewDownload(r) {
+			if err := models.ConsumeShareDownload(db, share); err != nil {
+				if errors.Is(err, models.ErrShareUnavailable) {
+					logShareAccess(db, cfg, r, share, file, models.ShareActionDownload, models.ShareStatusExhausted, 0)
+					renderPage(w, http.StatusGone, "share_unavailable.html",
+						shareUnavailablePage{Message: "This link has reached its download limit."})
+					return
+				}
+				logShareAccess(db, cfg, r, share, file, models.ShareActionDownload, models.ShareOutcomeError, 0)
+				http.Error(w, "Failed to access shared file", http.StatusInternalServerError)
+				return
+			}
+			recordAccess(db, file.ID, 0, models.AccessDownload)
+		}
+		if limited && storage.SupportsRanges() {
+			grantDownload(ctx, w, rdb, cfg, share, file)
+		}
+
+		serveSharedFile(w, r, db, cfg, storage, share, file, models.ShareActionDownload, "attachment", "")
+	}
+}
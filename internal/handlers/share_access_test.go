@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsNewDownload(t *testing.T) {
+	tests := []struct {
+		method string
+		rangeH string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodGet, "bytes=0-", true},
+		{http.MethodGet, "bytes=0-1023", true},
+		{http.MethodGet, "bytes=1024-", false},
+		{http.MethodGet, "bytes=-500", false},
+		{http.MethodHead, "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/share/abc/download", nil)
+		if tt.rangeH != "" {
+			r.Header.Set("Range", tt.rangeH)
+		}
+		if got := isNewDownload(r); got != tt.want {
+			t.Errorf("isNewDownload(%s, Range %q) = %v, want %v", tt.method, tt.rangeH, got, tt.want)
+		}
+	}
+}
+
+func TestIsRangeContinuation(t *testing.T) {
+	tests := []struct {
+		rangeH string
+		want   bool
+	}{
+		{"", false},
+		{"bytes=1024-", true},
+		{"bytes=1024-2047", true},
+		{"bytes=1-", true},
+		{"bytes=0-", false},
+		{"bytes=0-1023", false},
+		{"bytes=-500", false},
+		{"bytes=-99999999999", false},
+		{"bytes=1024-2047,4096-", false},
+		{"bytes=abc-", false},
+		{"items=1024-", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/share/abc/download", nil)
+		if tt.rangeH != "" {
+			r.Header.Set("Range", tt.rangeH)
+		}
+		if got := isRangeContinuation(r); got != tt.want {
+			t.Errorf("isRangeContinuation(Range %q) = %v, want %v", tt.rangeH, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/models"
+)
+
+const (
+	defaultAnalyticsPeriod = 30 * 24 * time.Hour
+	maxAnalyticsBuckets    = 1000
+)
+
+var analyticsIntervals = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 31 * 24 * time.Hour,
+}
+
+type analyticsRange struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+}
+
+// parseAnalyticsRange reads from, to and interval. The range defaults to the
+// last 30 days by day, and may not be split into more than
+// maxAnalyticsBuckets intervals.
+func parseAnalyticsRange(q url.Values) (analyticsRange, error) {
+	ar := analyticsRange{To: time.Now().UTC(), Interval: "day"}
+
+	if v := q.Get("to"); v != "" {
+		t, err := parseDateParam(v)
+		if err != nil {
+			return ar, errors.New("invalid to, expected RFC 3339 or YYYY-MM-DD")
+		}
+		ar.To = t
+	}
+	ar.From = ar.To.Add(-defaultAnalyticsPeriod)
+	if v := q.Get("from"); v != "" {
+		t, err := parseDateParam(v)
+		if err != nil {
+			return ar, errors.New("invalid from, expected RFC 3339 or YYYY-MM-DD")
+		}
+		ar.From = t
+	}
+	if !ar.From.Before(ar.To) {
+		return ar, errors.New("from must be before to")
+	}
+
+	if v := q.Get("interval"); v != "" {
+		if _, ok := analyticsIntervals[v]; !ok {
+			return ar, errors.New("invalid interval, expected hour, day, week or month")
+		}
+		ar.Interval = v
+	}
+	if ar.To.Sub(ar.From)/analyticsIntervals[ar.Interval] > maxAnalyticsBuckets {
+		return ar, errors.New("range too long for this interval")
+	}
+	return ar, nil
+}
+
+type ShareAnalyticsResponse struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Interval string    `json:"interval"`
+	*models.ShareAccessStats
+}
+
+type FileShareAnalyticsResponse struct {
+	ShareAnalyticsResponse
+	Shares []models.ShareAccessTotals `json:"shares"`
+}
+
+// ShareAnalyticsHandler summarises how one share link was used: successful
+// views, previews and downloads, refused requests by outcome, bytes sent and
+// distinct client addresses, in total and per interval.
+func ShareAnalyticsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		ar, err := parseAnalyticsRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		share := ownShareFromRequest(w, r, db, userID)
+		if share == nil {
+			return
+		}
+
+		stats, err := models.GetShareAccessStats(db, nil, &share.ID, ar.From, ar.To, ar.Interval)
+		if err != nil {
+			http.Error(w, "Failed to get share analytics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ShareAnalyticsResponse{From: ar.From, To: ar.To, Interval: ar.Interval, ShareAccessStats: stats})
+	}
+}
+
+// FileShareAnalyticsHandler is ShareAnalyticsHandler across all links sharing
+// a file on its own, with a breakdown by link.
+func FileShareAnalyticsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		ar, err := parseAnalyticsRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		file := ownedFileFromRequest(w, r, db, userID)
+		if file == nil {
+			return
+		}
+
+		stats, err := models.GetShareAccessStats(db, &file.ID, nil, ar.From, ar.To, ar.Interval)
+		if err != nil {
+			http.Error(w, "Failed to get share analytics", http.StatusInternalServerError)
+			return
+		}
+		totals, err := models.GetShareAccessTotalsByShare(db, file.ID, ar.From, ar.To)
+		if err != nil {
+			http.Error(w, "Failed to get share analytics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FileShareAnalyticsResponse{
+			ShareAnalyticsResponse: ShareAnalyticsResponse{From: ar.From, To: ar.To, Interval: ar.Interval, ShareAccessStats: stats},
+			Shares:                 totals,
+		})
+	}
+}
+
+type ShareAccessLogResponse struct {
+	Accesses []*models.ShareAccess `json:"accesses"`
+	Before   int64                 `json:"before,omitempty"`
+}
+
+// ShareAccessLogHandler returns the raw access log of a share link, newest
+// first. Pass the returned before to get the next page.
+func ShareAccessLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		q := r.URL.Query()
+		limit, err := parseLimit(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var before int64
+		if v := q.Get("before"); v != "" {
+			if before, err = strconv.ParseInt(v, 10, 64); err != nil || before < 1 {
+				http.Error(w, "Invalid before", http.StatusBadRequest)
+				return
+			}
+		}
+
+		share := ownShareFromRequest(w, r, db, userID)
+		if share == nil {
+			return
+		}
+
+		accesses, err := models.GetShareAccesses(db, share.ID, before, limit)
+		if err != nil {
+			http.Error(w, "Failed to get share access log", http.StatusInternalServerError)
+			return
+		}
+
+		response := ShareAccessLogResponse{Accesses: accesses}
+		if response.Accesses == nil {
+			response.Accesses = []*models.ShareAccess{}
+		}
+		if len(accesses) == limit {
+			response.Before = accesses[len(accesses)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/fakubwoy/go-file-share/internal/archive"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+type shareCollectionFile struct {
+	ID         int
+	Path       string
+	Size       string
+	Type       string
+	CanPreview bool
+}
+
+type shareCollectionPage struct {
+	Token              string
+	Title              string
+	Uploader           string
+	ExpiresAt          string
+	LimitedDownloads   bool
+	RemainingDownloads int
+	CanDownload        bool
+	TotalSize          string
+	Files              []shareCollectionFile
+}
+
+// shareCollectionTitle names a folder or selection link: its label, else the
+// folder's name.
+func shareCollectionTitle(db *sql.DB, share *models.Share) string {
+	if share.Label != "" {
+		return share.Label
+	}
+	if share.FolderID != nil {
+		if folder, err := models.GetFolderByID(db, *share.FolderID, share.CreatedBy); err == nil {
+			return folder.Name
+		}
+	}
+	return "Shared files"
+}
+
+// renderShareCollection is SharePageHandler for folder and selection links:
+// a listing of the shared files with per-file and whole-set downloads.
+func renderShareCollection(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *config.Config, share *models.Share) {
+	entries, err := models.GetShareEntries(db, share)
+	if err != nil {
+		logShareAccess(db, cfg, r, share, nil, models.ShareActionPage, models.ShareOutcomeError, 0)
+		http.Error(w, "Failed to list shared files", http.StatusInternalServerError)
+		return
+	}
+
+	page := shareCollectionPage{
+		Token:       share.Token,
+		Title:       shareCollectionTitle(db, share),
+		CanDownload: share.Permission == models.SharePermissionDownload,
+	}
+	if remaining := share.RemainingDownloads(); remaining != nil {
+		page.LimitedDownloads = true
+		page.RemainingDownloads = *remaining
+	}
+	if share.ExpiresAt != nil {
+		page.ExpiresAt = share.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+	if owner, err := models.GetUserByID(db, share.CreatedBy); err == nil {
+		page.Uploader = owner.DisplayName
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+		page.Files = append(page.Files, shareCollectionFile{
+			ID:   e.ID,
+			Path: e.Path,
+			Size: formatSize(e.Size),
+			Type: e.Type,
+			// As on single-file links, previews aren't offered when downloads
+			// are counted.
+			CanPreview: share.MaxDownloads == nil && previewKind(e.File) != "",
+		})
+	}
+	page.TotalSize = formatSize(total)
+
+	logShareAccess(db, cfg, r, share, nil, models.ShareActionPage, models.ShareOutcomeOK, 0)
+	renderPage(w, http.StatusOK, "share_collection.html", page)
+}
+
+// ShareArchiveHandler streams every file of a folder or selection link as one
+// ZIP (the default) or, with ?format=tar.gz, gzipped tar archive. Files are
+// read from storage one after another while the archive is written, so
+// nothing is staged on the server. The archive counts as a single download.
+func ShareArchiveHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share := shareFromRequest(w, r, db, rdb, cfg, models.ShareActionDownload)
+		if share == nil {
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = archive.FormatZip
+		}
+		if !archive.IsValidFormat(format) {
+			http.Error(w, "Invalid format, expected zip or tar.gz", http.StatusBadRequest)
+			return
+		}
+
+		if share.Permission != models.SharePermissionDownload {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, models.ShareOutcomeDenied, 0)
+			http.Error(w, "This link only allows viewing the files", http.StatusForbidden)
+			return
+		}
+
+		entries, err := models.GetShareEntries(db, share)
+		if err != nil {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, models.ShareOutcomeError, 0)
+			http.Error(w, "Failed to list shared files", http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			http.Error(w, "There are no files to download", http.StatusNotFound)
+			return
+		}
+		// Checked before the download is counted, so a refused archive
+		// doesn't use up a limited link.
+		if refuseLargeArchive(w, cfg, entries) {
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, models.ShareOutcomeDenied, 0)
+			return
+		}
+
+		if err := models.ConsumeShareDownload(db, share); err != nil {
+			if errors.Is(err, models.ErrShareUnavailable) {
+				logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, models.ShareStatusExhausted, 0)
+				renderPage(w, http.StatusGone, "share_unavailable.html",
+					shareUnavailablePage{Message: "This link has reached its download limit."})
+				return
+			}
+			logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, models.ShareOutcomeError, 0)
+			http.Error(w, "Failed to access shared files", http.StatusInternalServerError)
+			return
+		}
+
+		recordEntriesAccess(db, entries, 0, models.AccessDownload)
+
+		outcome := models.ShareOutcomeOK
+		bytes, err := writeArchive(w, storage, format, shareCollectionTitle(db, share), entries)
+		if err != nil {
+			log.Printf("Failed to stream archive of share %d: %v", share.ID, err)
+			outcome = models.ShareOutcomeError
+		}
+		logShareAccess(db, cfg, r, share, nil, models.ShareActionDownload, outcome, bytes)
+	}
+}
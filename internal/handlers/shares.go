@@ -2,37 +2,632 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
 	"github.com/fakubwoy/go-file-share/internal/models"
 	"github.com/fakubwoy/go-file-share/internal/storage"
 	"github.com/gorilla/mux"
 )
 
-func GetSharedFileHandler(db *sql.DB, storage storage.Storage) http.HandlerFunc {
+// ShareExpiryRequest sets when a share link stops working. At most one field
+// may be given; with none, the owner's default or the deployment default is
+// used. ExpiresIn takes a Go duration or a number of days such as "7d".
+type ShareExpiryRequest struct {
+	ExpiresAt    *time.Time `json:"expires_at"`
+	ExpiresIn    string     `json:"expires_in"`
+	NeverExpires bool       `json:"never_expires"`
+}
+
+type ShareRequest struct {
+	ShareExpiryRequest
+	Label        string `json:"label"`
+	Permission   string `json:"permission"`
+	Password     string `json:"password"`
+	MaxDownloads *int   `json:"max_downloads"`
+}
+
+// SelectionShareRequest shares several of the caller's files under one link.
+type SelectionShareRequest struct {
+	ShareRequest
+	FileIDs []int `json:"file_ids"`
+}
+
+const maxSelectionShareFiles = 1000
+
+// UpdateShareRequest changes only the fields that are present. An empty
+// Password removes the password and a MaxDownloads of 0 removes the limit.
+type UpdateShareRequest struct {
+	ShareExpiryRequest
+	Label        *string `json:"label"`
+	Password     *string `json:"password"`
+	MaxDownloads *int    `json:"max_downloads"`
+}
+
+type ShareSettingsRequest struct {
+	DefaultExpiry string `json:"default_expiry"`
+}
+
+// ShareSettingsResponse describes the owner's default and the limits the
+// deployment enforces. An empty MaxExpiry means there is no maximum.
+type ShareSettingsResponse struct {
+	DefaultExpiry      string `json:"default_expiry"`
+	ServerDefault      string `json:"server_default_expiry"`
+	MaxExpiry          string `json:"max_expiry,omitempty"`
+	NeverExpireAllowed bool   `json:"never_expire_allowed"`
+}
+
+var (
+	errNeverExpireNotAllowed = errors.New("Never-expiring share links are not allowed")
+	errConflictingExpiry     = errors.New("Only one of expires_at, expires_in and never_expires may be set")
+	// A limited link can't be previewed and a view link can't be downloaded,
+	// so a view link with a download limit couldn't be used at all.
+	errLimitedViewShare = errors.New("max_downloads needs download permission, view links can't be downloaded")
+)
+
+func neverExpireAllowed(cfg *config.Config) bool {
+	return cfg.ShareAllowNeverExpire && cfg.ShareMaxExpiry == 0
+}
+
+func (req ShareExpiryRequest) isSet() bool {
+	return req.ExpiresAt != nil || req.ExpiresIn != "" || req.NeverExpires
+}
+
+// explicitExpiry validates an expiry given in a request against the
+// deployment policy. It returns nil for a never-expiring link.
+func (req ShareExpiryRequest) explicitExpiry(cfg *config.Config, now time.Time) (*time.Time, error) {
+	set := 0
+	for _, given := range []bool{req.ExpiresAt != nil, req.ExpiresIn != "", req.NeverExpires} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errConflictingExpiry
+	}
+
+	if req.NeverExpires {
+		if !neverExpireAllowed(cfg) {
+			return nil, errNeverExpireNotAllowed
+		}
+		return nil, nil
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.UTC()
+	} else {
+		d, err := parseWithinParam(req.ExpiresIn)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid expires_in: %v", err)
+		}
+		expiresAt = now.Add(d)
+	}
+
+	if !expiresAt.After(now) {
+		return nil, errors.New("Expiry must be in the future")
+	}
+	if cfg.ShareMaxExpiry > 0 && expiresAt.After(now.Add(cfg.ShareMaxExpiry)) {
+		return nil, fmt.Errorf("Expiry exceeds the maximum of %s", cfg.ShareMaxExpiry)
+	}
+	return &expiresAt, nil
+}
+
+// defaultExpiry picks the expiry for a link created without one: the owner's
+// default if the current policy still allows it, otherwise the deployment
+// default.
+func defaultExpiry(cfg *config.Config, userDefault *time.Duration, now time.Time) *time.Time {
+	if userDefault != nil {
+		if *userDefault == 0 && neverExpireAllowed(cfg) {
+			return nil
+		}
+		if *userDefault > 0 && (cfg.ShareMaxExpiry == 0 || *userDefault <= cfg.ShareMaxExpiry) {
+			expiresAt := now.Add(*userDefault)
+			return &expiresAt
+		}
+	}
+	expiresAt := now.Add(cfg.ShareDefaultExpiry)
+	return &expiresAt
+}
+
+func formatExpiry(d *time.Duration) string {
+	switch {
+	case d == nil:
+		return ""
+	case *d == 0:
+		return "never"
+	}
+	return d.String()
+}
+
+type ShareResponse struct {
+	ID                 int        `json:"id"`
+	ShareURL           string     `json:"share_url"`
+	Kind               string     `json:"kind"`
+	FileID             *int       `json:"file_id,omitempty"`
+	FolderID           *int       `json:"folder_id,omitempty"`
+	Label              string     `json:"label"`
+	Permission         string     `json:"permission"`
+	PasswordProtected  bool       `json:"password_protected"`
+	MaxDownloads       *int       `json:"max_downloads"`
+	DownloadCount      int        `json:"download_count"`
+	RemainingDownloads *int       `json:"remaining_downloads"`
+	Status             string     `json:"status"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+func toShareResponse(s *models.Share, cfg *config.Config) ShareResponse {
+	return ShareResponse{
+		ID:                 s.ID,
+		ShareURL:           fmt.Sprintf("%s/share/%s", cfg.ServerBaseURL, s.Token),
+		Kind:               s.Kind(),
+		FileID:             s.FileID,
+		FolderID:           s.FolderID,
+		Label:              s.Label,
+		Permission:         s.Permission,
+		PasswordProtected:  s.PasswordHash != "",
+		MaxDownloads:       s.MaxDownloads,
+		DownloadCount:      s.DownloadCount,
+		RemainingDownloads: s.RemainingDownloads(),
+		Status:             s.Status(time.Now()),
+		CreatedAt:          s.CreatedAt,
+		ExpiresAt:          s.ExpiresAt,
+		RevokedAt:          s.RevokedAt,
+	}
+}
+
+// newShare validates a share request and turns it into a share owned by
+// userID, without a target yet. It writes an error response and returns nil
+// if the request is invalid.
+func newShare(w http.ResponseWriter, db *sql.DB, cfg *config.Config, userID int, req ShareRequest) *models.Share {
+	switch req.Permission {
+	case "":
+		req.Permission = models.SharePermissionDownload
+	case models.SharePermissionView, models.SharePermissionDownload:
+	default:
+		http.Error(w, "Invalid permission, expected view or download", http.StatusBadRequest)
+		return nil
+	}
+
+	if req.MaxDownloads != nil && *req.MaxDownloads < 1 {
+		http.Error(w, "max_downloads must be at least 1", http.StatusBadRequest)
+		return nil
+	}
+	if req.MaxDownloads != nil && req.Permission == models.SharePermissionView {
+		http.Error(w, errLimitedViewShare.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	var expiresAt *time.Time
+	var err error
+	if req.isSet() {
+		if expiresAt, err = req.explicitExpiry(cfg, now); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+	} else {
+		userDefault, err := models.GetDefaultShareExpiry(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to create share", http.StatusInternalServerError)
+			return nil
+		}
+		expiresAt = defaultExpiry(cfg, userDefault, now)
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		if passwordHash, err = auth.HashPassword(req.Password); err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return nil
+		}
+	}
+
+	return &models.Share{
+		Token:        auth.GenerateRandomString(32),
+		CreatedBy:    userID,
+		Label:        req.Label,
+		Permission:   req.Permission,
+		PasswordHash: passwordHash,
+		MaxDownloads: req.MaxDownloads,
+		ExpiresAt:    expiresAt,
+	}
+}
+
+// decodeShareRequest reads an optional JSON body into req.
+func decodeShareRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeShare(w http.ResponseWriter, cfg *config.Config, share *models.Share) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toShareResponse(share, cfg))
+}
+
+func writeShares(w http.ResponseWriter, cfg *config.Config, shares []*models.Share) {
+	response := make([]ShareResponse, 0, len(shares))
+	for _, s := range shares {
+		response = append(response, toShareResponse(s, cfg))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ShareFileHandler creates a new share link for a file. Existing links stay
+// valid, so each recipient can get their own link that is revoked separately.
+// The request body is optional.
+func ShareFileHandler(db *sql.DB, cfg *config.Config, storage storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		token := vars["token"]
+		userID := r.Context().Value("userID").(int)
+		fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid file ID", http.StatusBadRequest)
+			return
+		}
+
+		var req ShareRequest
+		if !decodeShareRequest(w, r, &req) {
+			return
+		}
+		share := newShare(w, db, cfg, userID, req)
+		if share == nil {
+			return
+		}
+
+		if _, err := models.GetFileByID(db, fileID, userID); err != nil {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		share.FileID = &fileID
+
+		if err := share.Create(db); err != nil {
+			http.Error(w, "Failed to share file", http.StatusInternalServerError)
+			return
+		}
+		writeShare(w, cfg, share)
+	}
+}
+
+// ShareFolderHandler creates a share link for a folder. Recipients see the
+// folder's files and subfolders as they are when the link is opened, so files
+// added later are shared too.
+func ShareFolderHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req ShareRequest
+		if !decodeShareRequest(w, r, &req) {
+			return
+		}
+		share := newShare(w, db, cfg, userID, req)
+		if share == nil {
+			return
+		}
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+		share.FolderID = &folder.ID
+
+		if err := share.Create(db); err != nil {
+			http.Error(w, "Failed to share folder", http.StatusInternalServerError)
+			return
+		}
+		writeShare(w, cfg, share)
+	}
+}
+
+// CreateSelectionShareHandler shares a fixed set of the caller's files under
+// one link.
+func CreateSelectionShareHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req SelectionShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		seen := map[int]bool{}
+		var fileIDs []int
+		for _, id := range req.FileIDs {
+			if !seen[id] {
+				seen[id] = true
+				fileIDs = append(fileIDs, id)
+			}
+		}
+		if len(fileIDs) == 0 {
+			http.Error(w, "file_ids is required", http.StatusBadRequest)
+			return
+		}
+		if len(fileIDs) > maxSelectionShareFiles {
+			http.Error(w, fmt.Sprintf("At most %d files can be shared at once", maxSelectionShareFiles), http.StatusBadRequest)
+			return
+		}
 
-		file, err := models.GetFileByShareToken(db, token)
+		share := newShare(w, db, cfg, userID, req.ShareRequest)
+		if share == nil {
+			return
+		}
+
+		if err := share.CreateSelection(db, fileIDs); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "One or more files not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to share files", http.StatusInternalServerError)
+			return
+		}
+		writeShare(w, cfg, share)
+	}
+}
+
+func ListSharesHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		file := ownedFileFromRequest(w, r, db, userID)
+		if file == nil {
+			return
+		}
+
+		shares, err := models.GetSharesByFile(db, file.ID, userID)
+		if err != nil {
+			http.Error(w, "Failed to get shares", http.StatusInternalServerError)
+			return
+		}
+		writeShares(w, cfg, shares)
+	}
+}
+
+func ListFolderSharesHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		folder := ownedFolderFromRequest(w, r, db, userID)
+		if folder == nil {
+			return
+		}
+
+		shares, err := models.GetSharesByFolder(db, folder.ID, userID)
+		if err != nil {
+			http.Error(w, "Failed to get shares", http.StatusInternalServerError)
+			return
+		}
+		writeShares(w, cfg, shares)
+	}
+}
+
+// ListAllSharesHandler lists every link the caller created: file, folder and
+// selection shares alike.
+func ListAllSharesHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		shares, err := models.GetSharesByUser(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get shares", http.StatusInternalServerError)
+			return
+		}
+		writeShares(w, cfg, shares)
+	}
+}
+
+// ownShareFromRequest loads the share named by the {shareID} route variable
+// if the caller created it. Under /files/{id}/shares the share must also be a
+// link to that file. It writes an error response and returns nil otherwise.
+func ownShareFromRequest(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int) *models.Share {
+	vars := mux.Vars(r)
+	shareID, err := strconv.Atoi(vars["shareID"])
+	if err != nil {
+		http.Error(w, "Invalid share ID", http.StatusBadRequest)
+		return nil
+	}
+
+	var share *models.Share
+	if v, ok := vars["id"]; ok {
+		fileID, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid file ID", http.StatusBadRequest)
+			return nil
+		}
+		share, err = models.GetShareByID(db, shareID, fileID, userID)
+	} else {
+		share, err = models.GetShareByOwner(db, shareID, userID)
+	}
+	if err != nil {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return nil
+	}
+	return share
+}
+
+// UpdateShareHandler changes a link's label, password or expiry, e.g. to
+// extend it. Changing the password signs out recipients who unlocked it.
+// expires_in counts from now, not from the current expiry. Expired links can
+// be brought back this way; revoked ones can't.
+func UpdateShareHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req UpdateShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		share := ownShareFromRequest(w, r, db, userID)
+		if share == nil {
+			return
+		}
+		if share.RevokedAt != nil {
+			http.Error(w, "Share has been revoked", http.StatusConflict)
+			return
+		}
+
+		if req.isSet() {
+			expiresAt, err := req.explicitExpiry(cfg, time.Now().UTC())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			share.ExpiresAt = expiresAt
+		}
+		if req.Label != nil {
+			share.Label = *req.Label
+		}
+		if req.MaxDownloads != nil {
+			switch {
+			case *req.MaxDownloads < 0:
+				http.Error(w, "max_downloads must not be negative", http.StatusBadRequest)
+				return
+			case *req.MaxDownloads == 0:
+				share.MaxDownloads = nil
+			case share.Permission == models.SharePermissionView:
+				http.Error(w, errLimitedViewShare.Error(), http.StatusBadRequest)
+				return
+			default:
+				share.MaxDownloads = req.MaxDownloads
+			}
+		}
+		if req.Password != nil {
+			share.PasswordHash = ""
+			if *req.Password != "" {
+				var err error
+				if share.PasswordHash, err = auth.HashPassword(*req.Password); err != nil {
+					http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		if err := models.UpdateShare(db, share); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Share has been revoked", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to update share", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toShareResponse(share, cfg))
+	}
+}
+
+func RevokeShareHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		share := ownShareFromRequest(w, r, db, userID)
+		if share == nil {
+			return
+		}
+
+		if err := models.RevokeShare(db, share.ID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Share not found or already revoked", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to revoke share", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func shareSettingsResponse(cfg *config.Config, userDefault *time.Duration) ShareSettingsResponse {
+	response := ShareSettingsResponse{
+		DefaultExpiry:      formatExpiry(userDefault),
+		ServerDefault:      cfg.ShareDefaultExpiry.String(),
+		NeverExpireAllowed: neverExpireAllowed(cfg),
+	}
+	if cfg.ShareMaxExpiry > 0 {
+		response.MaxExpiry = cfg.ShareMaxExpiry.String()
+	}
+	return response
+}
+
+func GetShareSettingsHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		userDefault, err := models.GetDefaultShareExpiry(db, userID)
 		if err != nil {
-			http.Error(w, "File not found or expired", http.StatusNotFound)
+			http.Error(w, "Failed to get share settings", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shareSettingsResponse(cfg, userDefault))
+	}
+}
+
+// UpdateShareSettingsHandler sets the owner's default lifetime for new links:
+// a duration such as "72h" or "7d", "never", or "" to use the deployment
+// default.
+func UpdateShareSettingsHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req ShareSettingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		var fileURL string
-		if file.S3URL != "" {
-			presignedURL, err := storage.GeneratePresignedURL(file.S3URL, 15*time.Minute)
+		var userDefault *time.Duration
+		switch req.DefaultExpiry {
+		case "":
+		case "never":
+			if !neverExpireAllowed(cfg) {
+				http.Error(w, errNeverExpireNotAllowed.Error(), http.StatusBadRequest)
+				return
+			}
+			never := time.Duration(0)
+			userDefault = &never
+		default:
+			d, err := parseWithinParam(req.DefaultExpiry)
 			if err != nil {
-				http.Error(w, "Failed to generate file URL", http.StatusInternalServerError)
+				http.Error(w, "Invalid default_expiry", http.StatusBadRequest)
 				return
 			}
-			fileURL = presignedURL
-		} else {
-			fileURL = file.LocalPath
+			// The default is kept in whole seconds, where zero means never.
+			if d < time.Second {
+				http.Error(w, "default_expiry must be at least 1s", http.StatusBadRequest)
+				return
+			}
+			d = (d + time.Second - 1).Truncate(time.Second)
+			if cfg.ShareMaxExpiry > 0 && d > cfg.ShareMaxExpiry {
+				http.Error(w, fmt.Sprintf("Default expiry exceeds the maximum of %s", cfg.ShareMaxExpiry), http.StatusBadRequest)
+				return
+			}
+			userDefault = &d
+		}
+
+		if err := models.SetDefaultShareExpiry(db, userID, userDefault); err != nil {
+			http.Error(w, "Failed to update share settings", http.StatusInternalServerError)
+			return
 		}
 
-		http.Redirect(w, r, fileURL, http.StatusFound)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shareSettingsResponse(cfg, userDefault))
 	}
 }
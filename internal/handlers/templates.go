@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// pageTemplates holds the HTML pages shown to people following a link
+// without an account. Each page includes the shared "header" and "footer".
+var pageTemplates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// renderPage executes a page template into a buffer first, so that a template
+// error becomes a clean 500 instead of a half-written page.
+func renderPage(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("Failed to render %s: %v", name, err)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// twoFactorChallengeTTL is how long a user has after the password step
+	// to enter a code.
+	twoFactorChallengeTTL = 5 * time.Minute
+	// twoFactorMaxAttempts is how many codes can be tried per challenge
+	// before the password has to be entered again.
+	twoFactorMaxAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// TwoFactorChallengeResponse answers a correct password when a second factor
+// is needed. With EnrollmentRequired the user has yet to set up TOTP, which
+// they do through /login/2fa/enroll before answering the challenge.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired  bool   `json:"two_factor_required"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+	ChallengeToken     string `json:"challenge_token"`
+	ExpiresIn          int    `json:"expires_in"`
+}
+
+// TwoFactorRequest carries either a TOTP code or a recovery code. At login
+// it also carries the challenge token, and turning two-factor authentication
+// off takes the account password.
+type TwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+	Password       string `json:"password,omitempty"`
+}
+
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	Pending                bool `json:"pending"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// twoFactorChallenge is a login that got past the password and waits for the
+// second factor.
+type twoFactorChallenge struct {
+	key    string
+	userID int
+	enroll bool
+}
+
+func twoFactorChallengeKey(token string) string {
+	return "2fa_challenge:" + auth.HashToken(token)
+}
+
+// writeTwoFactorChallenge answers the password step of a login with a
+// challenge for the second factor.
+func writeTwoFactorChallenge(w http.ResponseWriter, r *http.Request, rdb *redis.Client, userID int, enroll bool) {
+	ctx := r.Context()
+	token := auth.GenerateRandomString(48)
+	key := twoFactorChallengeKey(token)
+
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", userID, "enroll", enroll)
+		pipe.Expire(ctx, key, twoFactorChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to store two-factor challenge: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TwoFactorChallengeResponse{
+		TwoFactorRequired:  true,
+		EnrollmentRequired: enroll,
+		ChallengeToken:     token,
+		ExpiresIn:          int(twoFactorChallengeTTL.Seconds()),
+	})
+}
+
+// twoFactorChallengeFromRequest looks up the challenge a request answers and
+// counts the attempt. It writes the error response and returns nil if the
+// challenge is unknown, expired or out of attempts.
+func twoFactorChallengeFromRequest(w http.ResponseWriter, ctx context.Context, rdb *redis.Client, token string) *twoFactorChallenge {
+	if token == "" {
+		http.Error(w, "challenge_token is required", http.StatusBadRequest)
+		return nil
+	}
+	key := twoFactorChallengeKey(token)
+
+	var fields *redis.StringStringMapCmd
+	var attempts *redis.IntCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, key)
+		attempts = pipe.HIncrBy(ctx, key, "attempts", 1)
+		// Keeps the key from outliving the challenge should it expire
+		// between the two commands.
+		pipe.ExpireNX(ctx, key, twoFactorChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to look up two-factor challenge: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return nil
+	}
+
+	userID, err := strconv.Atoi(fields.Val()["user_id"])
+	if err != nil {
+		http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+		return nil
+	}
+	if attempts.Val() > twoFactorMaxAttempts {
+		rdb.Del(ctx, key)
+		http.Error(w, "Too many attempts, please log in again", http.StatusUnauthorized)
+		return nil
+	}
+	enroll, _ := strconv.ParseBool(fields.Val()["enroll"])
+	return &twoFactorChallenge{key: key, userID: userID, enroll: enroll}
+}
+
+// checkSecondFactor verifies a TOTP code or recovery code and uses it up.
+func checkSecondFactor(db *sql.DB, twoFactor *models.TwoFactor, req TwoFactorRequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		err := models.UseRecoveryCode(db, twoFactor.UserID, auth.HashRecoveryCode(req.RecoveryCode))
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return useTOTPCode(db, twoFactor, req.Code)
+}
+
+// useTOTPCode verifies a TOTP code and records its time step, so it can't be
+// replayed.
+func useTOTPCode(db *sql.DB, twoFactor *models.TwoFactor, code string) (bool, error) {
+	if twoFactor.Secret == "" {
+		return false, nil
+	}
+	step, ok := auth.ValidateTOTP(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := models.UseTOTPStep(db, twoFactor.UserID, step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string) {
+	codes := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashRecoveryCode(c)
+	}
+	return codes, hashes
+}
+
+// confirmTOTP completes a pending enrollment with a code from the
+// authenticator and returns the new recovery codes, or nil if the code is
+// wrong.
+func confirmTOTP(db *sql.DB, twoFactor *models.TwoFactor, code string) ([]string, error) {
+	ok, err := useTOTPCode(db, twoFactor, code)
+	if err != nil || !ok {
+		return nil, err
+	}
+	codes, hashes := newRecoveryCodes()
+	if err := models.EnableTOTP(db, twoFactor.UserID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// startTOTP begins enrollment with a new secret and writes it, along with the
+// otpauth URI to show as a QR code.
+func startTOTP(w http.ResponseWriter, db *sql.DB, cfg *config.Config, userID int) {
+	user, err := models.GetUserByID(db, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	secret := auth.GenerateTOTPSecret()
+	if err := models.StartTOTPEnrollment(db, userID, secret); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to start TOTP enrollment of user %d: %v", userID, err)
+		http.Error(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(cfg.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// TwoFactorLoginHandler completes a login with the second factor. For a
+// challenge that requires enrollment, the code confirms the new
+// authenticator and the answer includes the recovery codes.
+func TwoFactorLoginHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config, keys *auth.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var req TwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			http.Error(w, "code or recovery_code is required", http.StatusBadRequest)
+			return
+		}
+
+		challenge := twoFactorChallengeFromRequest(w, ctx, rdb, req.ChallengeToken)
+		if challenge == nil {
+			return
+		}
+		user, err := models.GetUserByID(db, challenge.userID)
+		if err != nil {
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		// Wrong codes count as failed logins of the account, so guessing
+		// them is throttled across challenges as well as within one.
+		limits := loginLimits(cfg, user.Email, auth.ClientIP(r, cfg))
+		if loginBlocked(w, r, rdb, limits) {
+			return
+		}
+		twoFactor, err := models.GetTwoFactor(db, challenge.userID)
+		if err != nil {
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+
+		var recoveryCodes []string
+		switch {
+		case twoFactor.Enabled():
+			ok, err := checkSecondFactor(db, twoFactor, req)
+			if err != nil {
+				log.Printf("Failed to check second factor of user %d: %v", challenge.userID, err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				if err := recordLoginFailure(r, db, rdb, cfg, limits, &user.ID, user.Email); err != nil {
+					log.Printf("Failed to record failed login: %v", err)
+				}
+				http.Error(w, "Invalid code", http.StatusUnauthorized)
+				return
+			}
+		case challenge.enroll && twoFactor.Pending():
+			recoveryCodes, err = confirmTOTP(db, twoFactor, req.Code)
+			if err != nil {
+				log.Printf("Failed to enable TOTP of user %d: %v", challenge.userID, err)
+				http.Error(w, "Failed to log in", http.StatusInternalServerError)
+				return
+			}
+			if recoveryCodes == nil {
+				if err := recordLoginFailure(r, db, rdb, cfg, limits, &user.ID, user.Email); err != nil {
+					log.Printf("Failed to record failed login: %v", err)
+				}
+				http.Error(w, "Invalid code", http.StatusUnauthorized)
+				return
+			}
+		case challenge.enroll:
+			http.Error(w, "Set up an authenticator through /login/2fa/enroll first", http.StatusBadRequest)
+			return
+		default:
+			// Two-factor authentication was turned off since the password
+			// step; the challenge can't be answered any more.
+			http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+			return
+		}
+
+		// Only one request gets to use the challenge.
+		if n, err := rdb.Del(ctx, challenge.key).Result(); err != nil || n == 0 {
+			http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+			return
+		}
+
+		clearLoginFailures(ctx, rdb, limits)
+
+		response, err := createTokens(r, db, cfg, keys, challenge.userID, "")
+		if err != nil {
+			log.Printf("Error issuing tokens for user %d: %v", challenge.userID, err)
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		response.RecoveryCodes = recoveryCodes
+		writeTokens(w, response)
+	}
+}
+
+// TwoFactorLoginEnrollHandler sets up TOTP for a user who has to enroll
+// before they can log in.
+func TwoFactorLoginEnrollHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		challenge := twoFactorChallengeFromRequest(w, r.Context(), rdb, req.ChallengeToken)
+		if challenge == nil {
+			return
+		}
+		if !challenge.enroll {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		startTOTP(w, db, cfg, challenge.userID)
+	}
+}
+
+// TwoFactorStatusHandler describes the caller's two-factor authentication.
+func TwoFactorStatusHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		twoFactor, err := models.GetTwoFactor(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		response := TwoFactorStatusResponse{
+			Enabled:  twoFactor.Enabled(),
+			Pending:  twoFactor.Pending(),
+			Required: cfg.Require2FA,
+		}
+		if twoFactor.Enabled() {
+			response.RecoveryCodesRemaining, err = models.CountUnusedRecoveryCodes(db, userID)
+			if err != nil {
+				http.Error(w, "Failed to get two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// StartTOTPHandler generates a TOTP secret for the caller. It takes effect
+// once confirmed with a code through ConfirmTOTPHandler.
+func StartTOTPHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		startTOTP(w, db, cfg, userID)
+	}
+}
+
+// ConfirmTOTPHandler enables TOTP once the caller proves their authenticator
+// works, and returns their recovery codes. They are shown only this once.
+func ConfirmTOTPHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req TwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		twoFactor, err := models.GetTwoFactor(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		if !twoFactor.Pending() {
+			http.Error(w, "No two-factor setup is in progress", http.StatusConflict)
+			return
+		}
+
+		codes, err := confirmTOTP(db, twoFactor, req.Code)
+		if err != nil {
+			log.Printf("Failed to enable TOTP of user %d: %v", userID, err)
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		if codes == nil {
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// checkAccountSecondFactor makes the caller confirm an account change with a
+// second factor, a TOTP code or, if allowRecovery, a recovery code. Wrong
+// codes count as failed logins, so a stolen access token can't be used to
+// guess them. On failure it answers the request and returns false.
+func checkAccountSecondFactor(w http.ResponseWriter, r *http.Request, db *sql.DB, rdb *redis.Client, cfg *config.Config, user *models.User, twoFactor *models.TwoFactor, req TwoFactorRequest, allowRecovery bool) bool {
+	limits := loginLimits(cfg, user.Email, auth.ClientIP(r, cfg))
+	if loginBlocked(w, r, rdb, limits) {
+		return false
+	}
+
+	var ok bool
+	var err error
+	if allowRecovery {
+		ok, err = checkSecondFactor(db, twoFactor, req)
+	} else {
+		ok, err = useTOTPCode(db, twoFactor, req.Code)
+	}
+	if err != nil {
+		log.Printf("Failed to check second factor of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to check the code", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		if err := recordLoginFailure(r, db, rdb, cfg, limits, &user.ID, user.Email); err != nil {
+			log.Printf("Failed to record failed login: %v", err)
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// DisableTOTPHandler turns two-factor authentication off. It takes a current
+// code and the account password, so a stolen access token alone can't weaken
+// the account. Accounts that only sign in through the identity provider have
+// no password and give just the code.
+func DisableTOTPHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		if cfg.Require2FA {
+			http.Error(w, "Two-factor authentication is required on this server", http.StatusForbidden)
+			return
+		}
+
+		var req TwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		twoFactor, err := models.GetTwoFactor(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		// The code goes first: a correct password clears the account's
+		// failed logins, which would otherwise reset the count of wrong
+		// codes on every attempt.
+		if twoFactor.Enabled() && !checkAccountSecondFactor(w, r, db, rdb, cfg, user, twoFactor, req, true) {
+			return
+		}
+		if user.PasswordHash != "" && !checkCurrentPassword(w, r, db, rdb, cfg, user, req.Password) {
+			return
+		}
+
+		if err := models.DisableTOTP(db, userID); err != nil {
+			http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces the caller's recovery codes, for
+// when they have used most of them or fear they leaked. It takes a current
+// TOTP code.
+func RegenerateRecoveryCodesHandler(db *sql.DB, rdb *redis.Client, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req TwoFactorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "code is required", http.StatusBadRequest)
+			return
+		}
+
+		user, err := models.GetUserByID(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+			return
+		}
+		twoFactor, err := models.GetTwoFactor(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if !twoFactor.Enabled() {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+			return
+		}
+		if !checkAccountSecondFactor(w, r, db, rdb, cfg, user, twoFactor, req, false) {
+			return
+		}
+
+		codes, hashes := newRecoveryCodes()
+		if err := models.ReplaceRecoveryCodes(db, userID, hashes); err != nil {
+			http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
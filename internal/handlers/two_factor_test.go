@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/models"
+)
+
+// totpStepDB is a database driver that only knows the statement of
+// models.UseTOTPStep, keeping each user's last used step in memory.
+type totpStepDB struct {
+	mu    sync.Mutex
+	steps map[int64]int64
+}
+
+func (d *totpStepDB) Open(string) (driver.Conn, error) { return totpStepConn{d}, nil }
+
+type totpStepConn struct{ db *totpStepDB }
+
+func (c totpStepConn) Prepare(query string) (driver.Stmt, error) {
+	if !strings.Contains(query, "totp_last_step < $1") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return totpStepStmt{c.db}, nil
+}
+
+func (c totpStepConn) Close() error              { return nil }
+func (c totpStepConn) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+type totpStepStmt struct{ db *totpStepDB }
+
+func (s totpStepStmt) Close() error  { return nil }
+func (s totpStepStmt) NumInput() int { return 2 }
+
+func (s totpStepStmt) Exec(args []driver.Value) (driver.Result, error) {
+	step, userID := args[0].(int64), args[1].(int64)
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if last, ok := s.db.steps[userID]; ok && last >= step {
+		return driver.RowsAffected(0), nil
+	}
+	s.db.steps[userID] = step
+	return driver.RowsAffected(1), nil
+}
+
+func (s totpStepStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("no queries")
+}
+
+var registerTOTPStepDB sync.Once
+
+func TestUseTOTPCodeRejectsReplay(t *testing.T) {
+	registerTOTPStepDB.Do(func() {
+		sql.Register("totpstep", &totpStepDB{steps: map[int64]int64{}})
+	})
+	db, err := sql.Open("totpstep", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	secret := auth.GenerateTOTPSecret()
+	twoFactor := &models.TwoFactor{UserID: 1, Secret: secret}
+	now := time.Now()
+	code, err := auth.TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := useTOTPCode(db, twoFactor, code)
+	if err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want true, nil", ok, err)
+	}
+	ok, err = useTOTPCode(db, twoFactor, code)
+	if err != nil || ok {
+		t.Fatalf("replay = %v, %v; want false, nil", ok, err)
+	}
+
+	// A code from an earlier step is refused once a later one was used.
+	earlier, err := auth.TOTPCode(secret, now.Add(-30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if earlier != code {
+		if ok, err := useTOTPCode(db, twoFactor, earlier); err != nil || ok {
+			t.Errorf("earlier step = %v, %v; want false, nil", ok, err)
+		}
+	}
+
+	// Other users' steps are their own.
+	other := &models.TwoFactor{UserID: 2, Secret: secret}
+	if ok, err := useTOTPCode(db, other, code); err != nil || !ok {
+		t.Errorf("other user = %v, %v; want true, nil", ok, err)
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/auth"
+	"github.com/fakubwoy/go-file-share/internal/config"
+	"github.com/fakubwoy/go-file-share/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// UploadRequestRequest creates a file drop link into one of the caller's
+// folders. Expiry follows the same rules and defaults as share links.
+type UploadRequestRequest struct {
+	ShareExpiryRequest
+	FolderID     int      `json:"folder_id"`
+	Label        string   `json:"label"`
+	Message      string   `json:"message"`
+	Password     string   `json:"password"`
+	MaxFiles     *int     `json:"max_files"`
+	MaxFileSize  *int64   `json:"max_file_size"`
+	AllowedTypes []string `json:"allowed_types"`
+}
+
+type UploadRequestResponse struct {
+	ID                int        `json:"id"`
+	UploadURL         string     `json:"upload_url"`
+	FolderID          int        `json:"folder_id"`
+	Label             string     `json:"label"`
+	Message           string     `json:"message"`
+	PasswordProtected bool       `json:"password_protected"`
+	AllowedTypes      []string   `json:"allowed_types"`
+	MaxFiles          *int       `json:"max_files"`
+	MaxFileSize       *int64     `json:"max_file_size"`
+	FileCount         int        `json:"file_count"`
+	RemainingFiles    *int       `json:"remaining_files"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+}
+
+func toUploadRequestResponse(u *models.UploadRequest, cfg *config.Config) UploadRequestResponse {
+	return UploadRequestResponse{
+		ID:                u.ID,
+		UploadURL:         fmt.Sprintf("%s/drop/%s", cfg.ServerBaseURL, u.Token),
+		FolderID:          u.FolderID,
+		Label:             u.Label,
+		Message:           u.Message,
+		PasswordProtected: u.PasswordHash != "",
+		AllowedTypes:      u.AllowedTypes,
+		MaxFiles:          u.MaxFiles,
+		MaxFileSize:       u.MaxFileSize,
+		FileCount:         u.FileCount,
+		RemainingFiles:    u.RemainingFiles(),
+		Status:            u.Status(time.Now()),
+		CreatedAt:         u.CreatedAt,
+		ExpiresAt:         u.ExpiresAt,
+		RevokedAt:         u.RevokedAt,
+	}
+}
+
+// parseAllowedTypes normalises the allowed_types list: MIME types, major
+// types written as "image/*", or extensions starting with a dot.
+func parseAllowedTypes(types []string) ([]string, error) {
+	allowed := []string{}
+	seen := map[string]bool{}
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		isExt := strings.HasPrefix(t, ".") && len(t) > 1 && !strings.ContainsAny(t, "/*")
+		isMIME := strings.Count(t, "/") == 1 && !strings.HasPrefix(t, "/") && !strings.HasSuffix(t, "/")
+		if !isExt && !isMIME {
+			return nil, fmt.Errorf("invalid allowed type %q, expected a MIME type or an extension like .pdf", t)
+		}
+		seen[t] = true
+		allowed = append(allowed, t)
+	}
+	return allowed, nil
+}
+
+func CreateUploadRequestHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		var req UploadRequestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.MaxFiles != nil && *req.MaxFiles < 1 {
+			http.Error(w, "max_files must be at least 1", http.StatusBadRequest)
+			return
+		}
+		if req.MaxFileSize != nil && (*req.MaxFileSize < 1 || *req.MaxFileSize > cfg.DropMaxUploadSize) {
+			http.Error(w, fmt.Sprintf("max_file_size must be between 1 and %d", cfg.DropMaxUploadSize), http.StatusBadRequest)
+			return
+		}
+		allowedTypes, err := parseAllowedTypes(req.AllowedTypes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := models.GetFolderByID(db, req.FolderID, userID); err != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+
+		now := time.Now().UTC()
+		var expiresAt *time.Time
+		if req.isSet() {
+			if expiresAt, err = req.explicitExpiry(cfg, now); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			userDefault, err := models.GetDefaultShareExpiry(db, userID)
+			if err != nil {
+				http.Error(w, "Failed to create upload request", http.StatusInternalServerError)
+				return
+			}
+			expiresAt = defaultExpiry(cfg, userDefault, now)
+		}
+
+		var passwordHash string
+		if req.Password != "" {
+			if passwordHash, err = auth.HashPassword(req.Password); err != nil {
+				http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		upload := &models.UploadRequest{
+			Token:        auth.GenerateRandomString(32),
+			UserID:       userID,
+			FolderID:     req.FolderID,
+			Label:        strings.TrimSpace(req.Label),
+			Message:      strings.TrimSpace(req.Message),
+			PasswordHash: passwordHash,
+			AllowedTypes: allowedTypes,
+			MaxFiles:     req.MaxFiles,
+			MaxFileSize:  req.MaxFileSize,
+			ExpiresAt:    expiresAt,
+		}
+		if err := upload.Create(db); err != nil {
+			http.Error(w, "Failed to create upload request", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(toUploadRequestResponse(upload, cfg))
+	}
+}
+
+func ListUploadRequestsHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+
+		uploads, err := models.GetUploadRequestsByUser(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to get upload requests", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]UploadRequestResponse, 0, len(uploads))
+		for _, u := range uploads {
+			response = append(response, toUploadRequestResponse(u, cfg))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func GetUploadRequestHandler(db *sql.DB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		requestID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid upload request ID", http.StatusBadRequest)
+			return
+		}
+
+		upload, err := models.GetUploadRequestByID(db, requestID, userID)
+		if err != nil {
+			http.Error(w, "Upload request not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toUploadRequestResponse(upload, cfg))
+	}
+}
+
+// RevokeUploadRequestHandler closes a drop link. Files already received stay
+// in the folder.
+func RevokeUploadRequestHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		requestID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid upload request ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.RevokeUploadRequest(db, requestID, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Upload request not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to revoke upload request", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,15 @@
+package mail
+
+import (
+	"context"
+	"log"
+)
+
+// LogMailer writes messages to the log instead of sending them, for
+// development and deployments without a mail server.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailer returns the mailer selected by MAIL_DRIVER.
+func NewMailer(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "log":
+		return &LogMailer{}, nil
+	case "smtp":
+		return NewSMTPMailer(cfg)
+	}
+	return nil, fmt.Errorf("unknown mail driver %q, expected log or smtp", cfg.MailDriver)
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// SMTPMailer sends messages through an SMTP server, upgrading to TLS with
+// STARTTLS when the server offers it. Without a username it sends
+// unauthenticated, as local test servers such as Mailpit expect.
+type SMTPMailer struct {
+	host string
+	addr string
+	from *mail.Address
+	auth smtp.Auth
+}
+
+// smtpTimeout bounds a send whose context has no deadline, so a server that
+// stops answering can't hold the connection forever.
+const smtpTimeout = time.Minute
+
+func NewSMTPMailer(cfg *config.Config) (*SMTPMailer, error) {
+	if cfg.SMTPHost == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required with MAIL_DRIVER=smtp")
+	}
+	from, err := mail.ParseAddress(cfg.MailFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+
+	m := &SMTPMailer{
+		host: cfg.SMTPHost,
+		addr: net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+		from: from,
+	}
+	if cfg.SMTPUsername != "" {
+		// PlainAuth refuses to send the password unencrypted to anything
+		// but localhost.
+		m.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var buf bytes.Buffer
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := m.from.Address[strings.LastIndex(m.from.Address, "@")+1:]
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	qp.Close()
+
+	return m.send(ctx, msg.To, buf.Bytes())
+}
+
+// send delivers data over a connection bound to ctx: it is dialed with ctx,
+// carries its deadline, and is closed when ctx ends, which aborts whatever
+// command is in progress.
+func (m *SMTPMailer) send(ctx context.Context, to string, data []byte) (err error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server doesn't support AUTH")
+		}
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
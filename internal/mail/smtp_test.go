@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// fakeSMTP accepts one connection and answers it with serve, returning a
+// mailer pointed at it.
+func fakeSMTP(t *testing.T, serve func(conn net.Conn)) *SMTPMailer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	m, err := NewSMTPMailer(&config.Config{SMTPHost: host, SMTPPort: port, MailFrom: "files@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	received := make(chan string, 1)
+	m := fakeSMTP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 fake ESMTP\r\n"))
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				received <- data.String()
+				conn.Write([]byte("250 queued\r\n"))
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"):
+				conn.Write([]byte("250-fake\r\n250 8BITMIME\r\n"))
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	})
+
+	err := m.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hello", Body: "Hi Jane\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		for _, want := range []string{"To: jane@example.com\r\n", "Subject: Hello\r\n", "Hi Jane\r\n"} {
+			if !strings.Contains(data, want) {
+				t.Errorf("message %q doesn't contain %q", data, want)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server got no message")
+	}
+}
+
+func TestSMTPMailerSendHonorsContext(t *testing.T) {
+	// The server greets and then never answers.
+	m := fakeSMTP(t, func(conn net.Conn) {
+		conn.Write([]byte("220 fake ESMTP\r\n"))
+		time.Sleep(5 * time.Second)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := m.Send(ctx, Message{To: "jane@example.com", Subject: "Hello", Body: "Hi"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send returned after %v, want it cut off by the context", elapsed)
+	}
+}
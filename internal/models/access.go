@@ -0,0 +1,124 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	AccessView     = "view"
+	AccessDownload = "download"
+)
+
+// RecordFileAccess logs a view or download of a file and updates the file's
+// last_accessed_at and download_count. userID is 0 for anonymous access.
+func RecordFileAccess(db *sql.DB, fileID, userID int, event string) error {
+	return RecordFileAccesses(db, []int{fileID}, userID, event)
+}
+
+// RecordFileAccesses is RecordFileAccess for several files at once, such as
+// the contents of an archive, in one transaction.
+func RecordFileAccesses(db *sql.DB, fileIDs []int, userID int, event string) error {
+	if len(fileIDs) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(fileIDs))
+	for i, id := range fileIDs {
+		ids[i] = int64(id)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO file_access_events (file_id, user_id, event) 
+              SELECT id, NULLIF($2, 0), $3 FROM unnest($1::int[]) AS id`, pq.Array(ids), userID, event); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE files SET last_accessed_at = NOW(), 
+              download_count = download_count + CASE WHEN $1 = 'download' THEN 1 ELSE 0 END 
+              WHERE id = ANY($2)`, event, pq.Array(ids)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FileAccessStats is how much a file is used, the part of a file that
+// changes on every view and download.
+type FileAccessStats struct {
+	LastAccessedAt *time.Time
+	DownloadCount  int64
+}
+
+// GetFileAccessStats returns the access stats of the files with the given
+// IDs that still exist.
+func GetFileAccessStats(db *sql.DB, fileIDs []int) (map[int]FileAccessStats, error) {
+	ids := make([]int64, len(fileIDs))
+	for i, id := range fileIDs {
+		ids[i] = int64(id)
+	}
+	rows, err := db.Query(`SELECT id, last_accessed_at, download_count FROM files WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int]FileAccessStats, len(fileIDs))
+	for rows.Next() {
+		var id int
+		var s FileAccessStats
+		if err := rows.Scan(&id, &s.LastAccessedAt, &s.DownloadCount); err != nil {
+			return nil, err
+		}
+		stats[id] = s
+	}
+	return stats, rows.Err()
+}
+
+// GetRecentFiles returns the files the user can still access, ordered by when
+// the user last viewed or downloaded them.
+func GetRecentFiles(db *sql.DB, userID, limit int) ([]*File, error) {
+	query := `SELECT ` + fileColumns + ` 
+              FROM files JOIN (
+                  SELECT file_id, MAX(created_at) AS accessed_at FROM file_access_events 
+                  WHERE user_id = $1 GROUP BY file_id
+              ) recent ON recent.file_id = files.id 
+              WHERE file_role(files.id, $1) IS NOT NULL 
+              ORDER BY recent.accessed_at DESC LIMIT $2`
+	rows, err := db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+func StarFile(db *sql.DB, userID, fileID int) error {
+	query := `INSERT INTO file_stars (user_id, file_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := db.Exec(query, userID, fileID)
+	return err
+}
+
+func UnstarFile(db *sql.DB, userID, fileID int) error {
+	query := `DELETE FROM file_stars WHERE user_id = $1 AND file_id = $2`
+	_, err := db.Exec(query, userID, fileID)
+	return err
+}
+
+// GetStarredFiles returns the files the user starred and can still access,
+// most recently starred first.
+func GetStarredFiles(db *sql.DB, userID int) ([]*File, error) {
+	query := `SELECT ` + fileColumns + ` 
+              FROM files JOIN file_stars ON file_stars.file_id = files.id 
+              WHERE file_stars.user_id = $1 AND file_role(files.id, $1) IS NOT NULL 
+              ORDER BY file_stars.created_at DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
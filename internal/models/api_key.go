@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes an API key can be given.
+const (
+	ScopeFilesRead    = "files:read"
+	ScopeFilesWrite   = "files:write"
+	ScopeSharesCreate = "shares:create"
+)
+
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopeFilesRead, ScopeFilesWrite, ScopeSharesCreate:
+		return true
+	}
+	return false
+}
+
+// APIKey is a long-lived credential acting for its user within Scopes.
+// AllowedIPs holds addresses and CIDR ranges; empty means any address.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IsActive reports whether the key can be used at time now.
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(now))
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, allowed_ips, created_at, expires_at,
+              last_used_at, last_used_ip, revoked_at`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	k := &APIKey{}
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes), pq.Array(&k.AllowedIPs),
+		&k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *APIKey) Create(db *sql.DB) error {
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)
+              RETURNING id, created_at`
+	return db.QueryRow(query, k.UserID, k.Name, k.Prefix, k.KeyHash, pq.Array(k.Scopes), pq.Array(k.AllowedIPs),
+		k.ExpiresAt).Scan(&k.ID, &k.CreatedAt)
+}
+
+func GetAPIKeyByHash(db *sql.DB, keyHash string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	return scanAPIKey(db.QueryRow(query, keyHash))
+}
+
+// GetAPIKeysByUser lists the user's keys, revoked ones included, newest first.
+func GetAPIKeysByUser(db *sql.DB, userID int) ([]*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records a use of the key. To spare a write per request, the
+// time is only moved on once a minute unless the address changed.
+func TouchAPIKey(db *sql.DB, keyID int, ip string) error {
+	query := `UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $1
+              WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $1)`
+	_, err := db.Exec(query, ip, keyID)
+	return err
+}
+
+func RevokeAPIKey(db *sql.DB, keyID, userID int) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	res, err := db.Exec(query, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
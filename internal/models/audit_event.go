@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Audit event types.
+const (
+	AuditLoginLockout   = "login_lockout"
+	AuditLoginIPLockout = "login_ip_lockout"
+	AuditPasswordChange = "password_change"
+	AuditAccountDelete  = "account_delete"
+)
+
+// AuditEvent records a security-relevant event. UserID is nil when the event
+// concerns no known account, e.g. failed logins for an unknown email.
+type AuditEvent struct {
+	ID        int
+	UserID    *int
+	Event     string
+	Email     string
+	IP        string
+	UserAgent string
+	Details   map[string]interface{}
+	CreatedAt time.Time
+}
+
+func (e *AuditEvent) Create(db *sql.DB) error {
+	details := []byte("{}")
+	if e.Details != nil {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return err
+		}
+	}
+	query := `INSERT INTO audit_events (user_id, event, email, ip, user_agent, details)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              RETURNING id, created_at`
+	return db.QueryRow(query, e.UserID, e.Event, e.Email, e.IP, e.UserAgent, details).Scan(&e.ID, &e.CreatedAt)
+}
@@ -2,117 +2,465 @@ package models
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type File struct {
-	ID         int       `json:"id"`
-	UserID     int       `json:"user_id"`
-	Name       string    `json:"name"`
-	Size       int64     `json:"size"`
-	Type       string    `json:"type"`
-	S3URL      string    `json:"s3_url,omitempty"`
-	LocalPath  string    `json:"local_path,omitempty"`
-	IsPublic   bool      `json:"is_public"`
-	ShareToken string    `json:"share_token,omitempty"`
-	ExpiresAt  time.Time `json:"expires_at,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	FolderID       *int       `json:"folder_id"`
+	Name           string     `json:"name"`
+	Size           int64      `json:"size"`
+	Type           string     `json:"type"`
+	Description    string     `json:"description"`
+	Tags           []string   `json:"tags"`
+	S3URL          string     `json:"s3_url,omitempty"`
+	LocalPath      string     `json:"local_path,omitempty"`
+	IsPublic       bool       `json:"is_public"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	DownloadCount  int64      `json:"download_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// fileColumns is qualified so that it can be selected from joins. A file is
+// public while it has at least one usable share link.
+const fileColumns = `files.id, files.user_id, files.folder_id, files.name, files.size, files.type, files.description, 
+              files.tags, files.s3_url, files.local_path, 
+              EXISTS (SELECT 1 FROM shares WHERE shares.file_id = files.id AND shares.revoked_at IS NULL 
+                      AND (shares.expires_at IS NULL OR shares.expires_at > NOW())) AS is_public, 
+              files.expires_at, files.last_accessed_at, files.download_count, files.created_at, files.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFile scans a row selected with fileColumns, followed by any extra
+// columns the query appended.
+func scanFile(row rowScanner, extra ...interface{}) (*File, error) {
+	f := &File{}
+	dest := []interface{}{
+		&f.ID, &f.UserID, &f.FolderID, &f.Name, &f.Size, &f.Type, &f.Description, pq.Array(&f.Tags),
+		&f.S3URL, &f.LocalPath, &f.IsPublic, &f.ExpiresAt,
+		&f.LastAccessedAt, &f.DownloadCount, &f.CreatedAt, &f.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func scanFiles(rows *sql.Rows) ([]*File, error) {
+	defer rows.Close()
+
+	var files []*File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
 }
 
 func (f *File) Create(db *sql.DB) error {
-	query := `INSERT INTO files (user_id, name, size, type, s3_url, local_path, is_public, share_token, expires_at) 
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+	if f.Tags == nil {
+		f.Tags = []string{}
+	}
+	query := `INSERT INTO files (user_id, folder_id, name, size, type, description, tags, s3_url, local_path, expires_at) 
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) 
               RETURNING id, created_at, updated_at`
-	return db.QueryRow(query, f.UserID, f.Name, f.Size, f.Type, f.S3URL, f.LocalPath,
-		f.IsPublic, f.ShareToken, f.ExpiresAt).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	return db.QueryRow(query, f.UserID, f.FolderID, f.Name, f.Size, f.Type, f.Description, pq.Array(f.Tags),
+		f.S3URL, f.LocalPath, f.ExpiresAt).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
 }
 
 func GetFileByID(db *sql.DB, fileID, userID int) (*File, error) {
-	f := &File{}
-	query := `SELECT id, user_id, name, size, type, s3_url, local_path, is_public, 
-              share_token, expires_at, created_at, updated_at 
+	query := `SELECT ` + fileColumns + ` 
               FROM files WHERE id = $1 AND user_id = $2`
-	err := db.QueryRow(query, fileID, userID).Scan(
-		&f.ID, &f.UserID, &f.Name, &f.Size, &f.Type, &f.S3URL, &f.LocalPath,
-		&f.IsPublic, &f.ShareToken, &f.ExpiresAt, &f.CreatedAt, &f.UpdatedAt)
+	return scanFile(db.QueryRow(query, fileID, userID))
+}
+
+// GetAccessibleFile loads a file for any user with access to it and returns
+// the strongest role they hold: RoleOwner for the owner, otherwise the role
+// granted on the file or one of its folders. Users without access get
+// sql.ErrNoRows, as if the file didn't exist.
+func GetAccessibleFile(db *sql.DB, fileID, userID int) (*File, string, error) {
+	var role sql.NullString
+	query := `SELECT ` + fileColumns + `, file_role(files.id, $2) 
+              FROM files WHERE files.id = $1`
+	f, err := scanFile(db.QueryRow(query, fileID, userID), &role)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return f, nil
+	if !role.Valid {
+		return nil, "", sql.ErrNoRows
+	}
+	return f, role.String, nil
 }
 
-func GetFilesByUser(db *sql.DB, userID int) ([]*File, error) {
-	query := `SELECT id, user_id, name, size, type, s3_url, local_path, is_public, 
-              share_token, expires_at, created_at, updated_at 
-              FROM files WHERE user_id = $1`
-	rows, err := db.Query(query, userID)
+// UpdateFile saves a file's name, description, tags and folder.
+func UpdateFile(db *sql.DB, f *File) error {
+	if f.Tags == nil {
+		f.Tags = []string{}
+	}
+	query := `UPDATE files SET name = $1, description = $2, tags = $3, folder_id = $4, updated_at = NOW() 
+              WHERE id = $5 RETURNING updated_at`
+	return db.QueryRow(query, f.Name, f.Description, pq.Array(f.Tags), f.FolderID, f.ID).Scan(&f.UpdatedAt)
+}
+
+// GetFilesInFolder lists the files directly inside a folder, by name.
+func GetFilesInFolder(db *sql.DB, folderID int) ([]*File, error) {
+	query := `SELECT ` + fileColumns + ` 
+              FROM files WHERE folder_id = $1 ORDER BY name, id`
+	rows, err := db.Query(query, folderID)
 	if err != nil {
 		return nil, err
 	}
+	return scanFiles(rows)
+}
+
+// FileEntry is a file with its path inside a set of files taken together,
+// such as a shared or downloaded folder: the file name, prefixed by the names
+// of the subfolders it is in.
+type FileEntry struct {
+	*File
+	Path string
+}
+
+func scanFileEntries(rows *sql.Rows) ([]*FileEntry, error) {
 	defer rows.Close()
 
-	var files []*File
+	var entries []*FileEntry
 	for rows.Next() {
-		f := &File{}
-		err := rows.Scan(
-			&f.ID, &f.UserID, &f.Name, &f.Size, &f.Type, &f.S3URL, &f.LocalPath,
-			&f.IsPublic, &f.ShareToken, &f.ExpiresAt, &f.CreatedAt, &f.UpdatedAt)
+		e := &FileEntry{}
+		f, err := scanFile(rows, &e.Path)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, f)
+		e.File = f
+		entries = append(entries, e)
 	}
-	return files, nil
+	return entries, rows.Err()
 }
 
-func SearchFiles(db *sql.DB, userID int, query string) ([]*File, error) {
-	sqlQuery := `SELECT id, user_id, name, size, type, s3_url, local_path, is_public, 
-                share_token, expires_at, created_at, updated_at 
-                FROM files WHERE user_id = $1 AND name LIKE '%' || $2 || '%'`
-	rows, err := db.Query(sqlQuery, userID, query)
+// GetFolderTreeFiles lists every file of the user's in a folder and its
+// subfolders, ordered by path relative to the folder.
+func GetFolderTreeFiles(db *sql.DB, folderID, userID int) ([]*FileEntry, error) {
+	query := `WITH RECURSIVE tree(id, path, seen) AS (
+                  SELECT id, ''::TEXT, ARRAY[id] FROM folders WHERE id = $1 AND user_id = $2
+                  UNION ALL
+                  SELECT folders.id, tree.path || folders.name || '/', tree.seen || folders.id
+                  FROM folders JOIN tree ON folders.parent_id = tree.id
+                  WHERE folders.id <> ALL(tree.seen)
+              )
+              SELECT ` + fileColumns + `, tree.path || files.name
+              FROM files JOIN tree ON files.folder_id = tree.id
+              WHERE files.user_id = $2
+              ORDER BY tree.path || files.name, files.id`
+	rows, err := db.Query(query, folderID, userID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanFileEntries(rows)
+}
 
-	var files []*File
-	for rows.Next() {
-		f := &File{}
-		err := rows.Scan(
-			&f.ID, &f.UserID, &f.Name, &f.Size, &f.Type, &f.S3URL, &f.LocalPath,
-			&f.IsPublic, &f.ShareToken, &f.ExpiresAt, &f.CreatedAt, &f.UpdatedAt)
+// GetFilesByIDs loads those of the given files the user owns, by name.
+func GetFilesByIDs(db *sql.DB, fileIDs []int, userID int) ([]*File, error) {
+	ids := make([]int64, len(fileIDs))
+	for i, id := range fileIDs {
+		ids[i] = int64(id)
+	}
+	query := `SELECT ` + fileColumns + ` 
+              FROM files WHERE id = ANY($1) AND user_id = $2 ORDER BY name, id`
+	rows, err := db.Query(query, pq.Array(ids), userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+func GetFilesByUser(db *sql.DB, userID int) ([]*File, error) {
+	query := `SELECT ` + fileColumns + ` 
+              FROM files WHERE user_id = $1 ORDER BY id`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// does not belong to the requested sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FileFilter narrows a file listing or search. Zero values leave a field
+// unconstrained. MimeType matches exactly, or by major type when given as
+// "image/*"; a file must carry every one of Tags.
+type FileFilter struct {
+	MimeType      string
+	Tags          []string
+	MinSize       int64
+	MaxSize       int64
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// FileListOptions controls sorting and keyset pagination of a file listing.
+type FileListOptions struct {
+	FileFilter
+	SortBy string
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+// FilePage is one page of a file listing. NextCursor is empty on the last page.
+type FilePage struct {
+	Files      []*File
+	NextCursor string
+	TotalCount int
+}
+
+// sortColumns maps the accepted sort keys to their column and the type used to
+// cast the cursor value back when comparing.
+var sortColumns = map[string]struct {
+	column string
+	cast   string
+}{
+	"name":       {"name", "text"},
+	"size":       {"size", "bigint"},
+	"created_at": {"created_at", "timestamp"},
+	"updated_at": {"updated_at", "timestamp"},
+}
+
+// IsValidSortKey reports whether key can be used as FileListOptions.SortBy.
+func IsValidSortKey(key string) bool {
+	_, ok := sortColumns[key]
+	return ok
+}
+
+type fileCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+func encodeFileCursor(sortBy string, f *File) string {
+	c := fileCursor{Sort: sortBy, ID: f.ID}
+	switch sortBy {
+	case "name":
+		c.Value = f.Name
+	case "size":
+		c.Value = fmt.Sprintf("%d", f.Size)
+	case "created_at":
+		c.Value = f.CreatedAt.Format(cursorTimeLayout)
+	case "updated_at":
+		c.Value = f.UpdatedAt.Format(cursorTimeLayout)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFileCursor(cursor, sortBy string) (*fileCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &fileCursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.Sort != sortBy {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// where appends the filter's conditions to conds, numbering placeholders after
+// the arguments already in args.
+func (ff FileFilter) where(conds []string, args []interface{}) ([]string, []interface{}) {
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if ff.MimeType != "" {
+		if major, ok := strings.CutSuffix(ff.MimeType, "/*"); ok {
+			add("type LIKE $%d", major+"/%")
+		} else {
+			add("type = $%d", ff.MimeType)
+		}
+	}
+	if len(ff.Tags) > 0 {
+		add("tags @> $%d", pq.Array(ff.Tags))
+	}
+	if ff.MinSize > 0 {
+		add("size >= $%d", ff.MinSize)
+	}
+	if ff.MaxSize > 0 {
+		add("size <= $%d", ff.MaxSize)
+	}
+	if !ff.CreatedAfter.IsZero() {
+		add("created_at >= $%d", ff.CreatedAfter)
+	}
+	if !ff.CreatedBefore.IsZero() {
+		add("created_at < $%d", ff.CreatedBefore)
+	}
+	return conds, args
+}
+
+// ListFiles returns one page of the user's files using keyset pagination on
+// (sort column, id), so deep pages cost the same as the first one.
+func ListFiles(db *sql.DB, userID int, opts FileListOptions) (*FilePage, error) {
+	sort, ok := sortColumns[opts.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort key %q", opts.SortBy)
+	}
+
+	conds, args := opts.FileFilter.where([]string{"user_id = $1"}, []interface{}{userID})
+
+	page := &FilePage{}
+	countQuery := `SELECT COUNT(*) FROM files WHERE ` + strings.Join(conds, " AND ")
+	if err := db.QueryRow(countQuery, args...).Scan(&page.TotalCount); err != nil {
+		return nil, err
+	}
+
+	dir, cmp := "ASC", ">"
+	if opts.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeFileCursor(opts.Cursor, opts.SortBy)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, f)
+		args = append(args, c.Value, c.ID)
+		conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)",
+			sort.column, cmp, len(args)-1, sort.cast, len(args)))
+	}
+
+	args = append(args, opts.Limit+1)
+	query := fmt.Sprintf(`SELECT %s 
+              FROM files WHERE %s 
+              ORDER BY %s %s, id %s LIMIT $%d`,
+		fileColumns, strings.Join(conds, " AND "), sort.column, dir, dir, len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	files, err := scanFiles(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > opts.Limit {
+		files = files[:opts.Limit]
+		page.NextCursor = encodeFileCursor(opts.SortBy, files[len(files)-1])
 	}
-	return files, nil
+	page.Files = files
+	return page, nil
 }
 
-func DeleteFile(db *sql.DB, fileID, userID int) error {
-	query := `DELETE FROM files WHERE id = $1 AND user_id = $2`
-	_, err := db.Exec(query, fileID, userID)
-	return err
+// FileSearchResult is a file matched by full-text search. Snippet holds
+// fragments of the description or extracted content as HTML: the text is
+// escaped and matches are wrapped in <mark></mark>.
+type FileSearchResult struct {
+	*File
+	Rank    float64
+	Snippet string
 }
 
-func MakeFilePublic(db *sql.DB, fileID, userID int, token string, expiresAt time.Time) error {
-	query := `UPDATE files SET is_public = true, share_token = $1, expires_at = $2 
-              WHERE id = $3 AND user_id = $4`
-	_, err := db.Exec(query, token, expiresAt, fileID, userID)
-	return err
+// SearchFiles runs a web-style full-text query ("quarterly -draft", quoted
+// phrases, "or") against names, tags, descriptions and extracted content.
+func SearchFiles(db *sql.DB, userID int, query string, filter FileFilter, limit int) ([]*FileSearchResult, error) {
+	conds, args := filter.where(
+		[]string{"user_id = $1", "search_vector @@ q"}, []interface{}{userID, query})
+	args = append(args, limit)
+
+	sqlQuery := fmt.Sprintf(`SELECT %s, 
+                ts_rank_cd(search_vector, q) AS rank, 
+                ts_headline('english', description || ' ' || coalesce(content_text, ''), q, 
+                    'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2, MaxWords=25, MinWords=8') AS snippet 
+                FROM files, websearch_to_tsquery('english', $2) q 
+                WHERE %s 
+                ORDER BY rank DESC, id DESC LIMIT $%d`,
+		fileColumns, strings.Join(conds, " AND "), len(args))
+	rows, err := db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchResults(rows)
 }
 
-func GetFileByShareToken(db *sql.DB, token string) (*File, error) {
-	f := &File{}
-	query := `SELECT id, user_id, name, size, type, s3_url, local_path, is_public, 
-              share_token, expires_at, created_at, updated_at 
-              FROM files WHERE share_token = $1 AND is_public = true AND (expires_at IS NULL OR expires_at > NOW())`
-	err := db.QueryRow(query, token).Scan(
-		&f.ID, &f.UserID, &f.Name, &f.Size, &f.Type, &f.S3URL, &f.LocalPath,
-		&f.IsPublic, &f.ShareToken, &f.ExpiresAt, &f.CreatedAt, &f.UpdatedAt)
+// Matches are marked with control characters by ts_headline, which doesn't
+// escape the text around them, and only turned into <mark> tags after
+// highlightSnippet has escaped it: descriptions and content can come from
+// anyone who can upload, e.g. through a drop link.
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+func highlightSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+func scanSearchResults(rows *sql.Rows) ([]*FileSearchResult, error) {
+	defer rows.Close()
+
+	var results []*FileSearchResult
+	for rows.Next() {
+		res := &FileSearchResult{}
+		f, err := scanFile(rows, &res.Rank, &res.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		res.File = f
+		res.Snippet = highlightSnippet(res.Snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// FuzzySearchFiles matches file names by trigram similarity, ignoring case and
+// accents, so misspellings like "reprot_final" still find "report_final.pdf".
+// Rank is the better of whole-name and best-matching-word similarity, 0 to 1.
+func FuzzySearchFiles(db *sql.DB, userID int, query string, filter FileFilter, limit int) ([]*FileSearchResult, error) {
+	conds, args := filter.where(
+		[]string{"user_id = $1", "(immutable_unaccent(lower(name)) % q OR q <% immutable_unaccent(lower(name)))"},
+		[]interface{}{userID, query})
+	args = append(args, limit)
+
+	sqlQuery := fmt.Sprintf(`SELECT %s, 
+                GREATEST(similarity(immutable_unaccent(lower(name)), q), 
+                         word_similarity(q, immutable_unaccent(lower(name)))) AS rank, 
+                '' AS snippet 
+                FROM files, immutable_unaccent(lower($2)) q 
+                WHERE %s 
+                ORDER BY rank DESC, id DESC LIMIT $%d`,
+		fileColumns, strings.Join(conds, " AND "), len(args))
+	rows, err := db.Query(sqlQuery, args...)
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	return scanSearchResults(rows)
+}
+
+func DeleteFile(db *sql.DB, fileID, userID int) error {
+	query := `DELETE FROM files WHERE id = $1 AND user_id = $2`
+	_, err := db.Exec(query, fileID, userID)
+	return err
 }
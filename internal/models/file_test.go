@@ -0,0 +1,20 @@
+package models
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"the \x02quarterly\x03 report", "the <mark>quarterly</mark> report"},
+		{"<script>alert(1)</script> \x02budget\x03", "&lt;script&gt;alert(1)&lt;/script&gt; <mark>budget</mark>"},
+		{`a "quoted" & 'single' <mark>`, "a &#34;quoted&#34; &amp; &#39;single&#39; &lt;mark&gt;"},
+	}
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.in); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrFolderNotEmpty is returned when deleting a folder that still holds
+	// files or subfolders.
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	// ErrFolderCycle is returned when moving a folder into itself or one of
+	// its own subfolders.
+	ErrFolderCycle = errors.New("folder cannot be moved into itself")
+)
+
+// Folder groups a user's files. ParentID is nil for top-level folders.
+type Folder struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const folderColumns = `folders.id, folders.user_id, folders.parent_id, folders.name,
+              folders.created_at, folders.updated_at`
+
+func scanFolder(row rowScanner, extra ...interface{}) (*Folder, error) {
+	f := &Folder{}
+	dest := []interface{}{&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.CreatedAt, &f.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func scanFolders(rows *sql.Rows) ([]*Folder, error) {
+	defer rows.Close()
+
+	var folders []*Folder
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+func (f *Folder) Create(db *sql.DB) error {
+	query := `INSERT INTO folders (user_id, parent_id, name) VALUES ($1, $2, $3)
+              RETURNING id, created_at, updated_at`
+	err := db.QueryRow(query, f.UserID, f.ParentID, f.Name).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// Update renames or moves the folder. Moving it below itself is refused with
+// ErrFolderCycle. The check and the move run in one transaction holding the
+// user's folders, so two moves at once can't make a cycle together.
+func (f *Folder) Update(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if f.ParentID != nil {
+		// The check below reads a new snapshot, taken after any move that
+		// held the locks first has committed.
+		if _, err := tx.Exec(`SELECT id FROM folders WHERE user_id = $1 ORDER BY id FOR UPDATE`, f.UserID); err != nil {
+			return err
+		}
+
+		var cycle bool
+		err = tx.QueryRow(`WITH RECURSIVE subtree(id) AS (
+                  SELECT $1::INTEGER
+                  UNION
+                  SELECT folders.id FROM folders JOIN subtree ON folders.parent_id = subtree.id
+              )
+              SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`, f.ID, *f.ParentID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+
+	err = tx.QueryRow(`UPDATE folders SET name = $1, parent_id = $2, updated_at = NOW()
+              WHERE id = $3 AND user_id = $4 RETURNING updated_at`,
+		f.Name, f.ParentID, f.ID, f.UserID).Scan(&f.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFolderByID loads one of the user's own folders.
+func GetFolderByID(db *sql.DB, folderID, userID int) (*Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folders WHERE id = $1 AND user_id = $2`
+	return scanFolder(db.QueryRow(query, folderID, userID))
+}
+
+// GetAccessibleFolder is GetAccessibleFile for folders.
+func GetAccessibleFolder(db *sql.DB, folderID, userID int) (*Folder, string, error) {
+	var role sql.NullString
+	query := `SELECT ` + folderColumns + `, folder_role(folders.id, $2)
+              FROM folders WHERE folders.id = $1`
+	f, err := scanFolder(db.QueryRow(query, folderID, userID), &role)
+	if err != nil {
+		return nil, "", err
+	}
+	if !role.Valid {
+		return nil, "", sql.ErrNoRows
+	}
+	return f, role.String, nil
+}
+
+// GetFoldersByUser lists all of the user's folders, by name.
+func GetFoldersByUser(db *sql.DB, userID int) ([]*Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folders WHERE user_id = $1 ORDER BY name, id`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// GetSubfolders lists the folders directly inside a folder, by name.
+func GetSubfolders(db *sql.DB, folderID int) ([]*Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folders WHERE parent_id = $1 ORDER BY name, id`
+	rows, err := db.Query(query, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// DeleteFolder removes an empty folder. Folders that still hold files or
+// subfolders are refused with ErrFolderNotEmpty so nothing is deleted by
+// accident.
+func DeleteFolder(db *sql.DB, folderID, userID int) error {
+	var empty bool
+	err := db.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM files WHERE folder_id = $1)
+              AND NOT EXISTS (SELECT 1 FROM folders WHERE parent_id = $1)`, folderID).Scan(&empty)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrFolderNotEmpty
+	}
+
+	res, err := db.Exec(`DELETE FROM folders WHERE id = $1 AND user_id = $2`, folderID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
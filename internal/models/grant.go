@@ -0,0 +1,189 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Roles a user can hold on a file or folder, weakest first. Each role
+// includes the ones before it. RoleOwner is never stored in a grant.
+const (
+	RoleView     = "view"
+	RoleDownload = "download"
+	RoleEdit     = "edit"
+	RoleOwner    = "owner"
+)
+
+var roleRank = map[string]int{RoleView: 1, RoleDownload: 2, RoleEdit: 3, RoleOwner: 4}
+
+// IsGrantableRole reports whether role can be given to another user.
+func IsGrantableRole(role string) bool {
+	return role == RoleView || role == RoleDownload || role == RoleEdit
+}
+
+// RoleAllows reports whether holding role is enough for an action that
+// requires the role required.
+func RoleAllows(role, required string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[required]
+}
+
+// Grant gives another registered user a role on a file, or on a folder and
+// everything in it. Exactly one of FileID and FolderID is set. GranteeEmail
+// and GranteeName are filled in when grants are listed.
+type Grant struct {
+	ID           int       `json:"id"`
+	FileID       *int      `json:"file_id,omitempty"`
+	FolderID     *int      `json:"folder_id,omitempty"`
+	GranteeID    int       `json:"grantee_id"`
+	GranteeEmail string    `json:"grantee_email"`
+	GranteeName  string    `json:"grantee_name"`
+	GrantedBy    int       `json:"granted_by"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Save creates the grant, or changes the role if the user already has a
+// grant on the same file or folder.
+func (g *Grant) Save(db *sql.DB) error {
+	target := `(file_id, grantee_id) WHERE file_id IS NOT NULL`
+	if g.FolderID != nil {
+		target = `(folder_id, grantee_id) WHERE folder_id IS NOT NULL`
+	}
+	query := `INSERT INTO grants (file_id, folder_id, grantee_id, granted_by, role)
+              VALUES ($1, $2, $3, $4, $5)
+              ON CONFLICT ` + target + ` DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by
+              RETURNING id, created_at`
+	return db.QueryRow(query, g.FileID, g.FolderID, g.GranteeID, g.GrantedBy, g.Role).Scan(&g.ID, &g.CreatedAt)
+}
+
+const grantColumns = `grants.id, grants.file_id, grants.folder_id, grants.grantee_id, users.email,
+              users.display_name, grants.granted_by, grants.role, grants.created_at`
+
+func scanGrants(rows *sql.Rows) ([]*Grant, error) {
+	defer rows.Close()
+
+	var grants []*Grant
+	for rows.Next() {
+		g := &Grant{}
+		if err := rows.Scan(&g.ID, &g.FileID, &g.FolderID, &g.GranteeID, &g.GranteeEmail,
+			&g.GranteeName, &g.GrantedBy, &g.Role, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+func GetFileGrants(db *sql.DB, fileID int) ([]*Grant, error) {
+	query := `SELECT ` + grantColumns + `
+              FROM grants JOIN users ON users.id = grants.grantee_id
+              WHERE grants.file_id = $1 ORDER BY grants.created_at, grants.id`
+	rows, err := db.Query(query, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return scanGrants(rows)
+}
+
+func GetFolderGrants(db *sql.DB, folderID int) ([]*Grant, error) {
+	query := `SELECT ` + grantColumns + `
+              FROM grants JOIN users ON users.id = grants.grantee_id
+              WHERE grants.folder_id = $1 ORDER BY grants.created_at, grants.id`
+	rows, err := db.Query(query, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return scanGrants(rows)
+}
+
+func revokeGrant(db *sql.DB, query string, grantID, targetID int) error {
+	res, err := db.Exec(query, grantID, targetID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func RevokeFileGrant(db *sql.DB, grantID, fileID int) error {
+	return revokeGrant(db, `DELETE FROM grants WHERE id = $1 AND file_id = $2`, grantID, fileID)
+}
+
+func RevokeFolderGrant(db *sql.DB, grantID, folderID int) error {
+	return revokeGrant(db, `DELETE FROM grants WHERE id = $1 AND folder_id = $2`, grantID, folderID)
+}
+
+// SharedFile is a file another user granted the caller access to.
+type SharedFile struct {
+	*File
+	Role       string
+	OwnerEmail string
+	OwnerName  string
+}
+
+// SharedFolder is a folder another user granted the caller access to.
+type SharedFolder struct {
+	*Folder
+	Role       string
+	OwnerEmail string
+	OwnerName  string
+}
+
+// GetFilesSharedWith lists the files granted directly to the user, newest
+// grant first. Files reached through a shared folder are listed with the
+// folder instead.
+func GetFilesSharedWith(db *sql.DB, userID int) ([]*SharedFile, error) {
+	query := `SELECT ` + fileColumns + `, grants.role, users.email, users.display_name
+              FROM grants
+              JOIN files ON files.id = grants.file_id
+              JOIN users ON users.id = files.user_id
+              WHERE grants.grantee_id = $1
+              ORDER BY grants.created_at DESC, grants.id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shared []*SharedFile
+	for rows.Next() {
+		s := &SharedFile{}
+		f, err := scanFile(rows, &s.Role, &s.OwnerEmail, &s.OwnerName)
+		if err != nil {
+			return nil, err
+		}
+		s.File = f
+		shared = append(shared, s)
+	}
+	return shared, rows.Err()
+}
+
+// GetFoldersSharedWith lists the folders granted directly to the user, newest
+// grant first.
+func GetFoldersSharedWith(db *sql.DB, userID int) ([]*SharedFolder, error) {
+	query := `SELECT ` + folderColumns + `, grants.role, users.email, users.display_name
+              FROM grants
+              JOIN folders ON folders.id = grants.folder_id
+              JOIN users ON users.id = folders.user_id
+              WHERE grants.grantee_id = $1
+              ORDER BY grants.created_at DESC, grants.id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shared []*SharedFolder
+	for rows.Next() {
+		s := &SharedFolder{}
+		f, err := scanFolder(rows, &s.Role, &s.OwnerEmail, &s.OwnerName)
+		if err != nil {
+			return nil, err
+		}
+		s.Folder = f
+		shared = append(shared, s)
+	}
+	return shared, rows.Err()
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const NotificationFilesReceived = "files_received"
+
+// Notification tells a user about something that happened to their files
+// while they weren't looking. URL points at the API resource it concerns.
+type Notification struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+func (n *Notification) Create(db *sql.DB) error {
+	query := `INSERT INTO notifications (user_id, kind, message, url) VALUES ($1, $2, $3, $4)
+              RETURNING id, created_at`
+	return db.QueryRow(query, n.UserID, n.Kind, n.Message, n.URL).Scan(&n.ID, &n.CreatedAt)
+}
+
+// GetNotifications returns the user's most recent notifications, newest
+// first, optionally only those not yet read.
+func GetNotifications(db *sql.DB, userID int, unreadOnly bool, limit int) ([]*Notification, error) {
+	query := `SELECT id, user_id, kind, message, url, created_at, read_at FROM notifications
+              WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+              ORDER BY created_at DESC, id DESC LIMIT $3`
+	rows, err := db.Query(query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		n := &Notification{}
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.Message, &n.URL, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func MarkNotificationRead(db *sql.DB, notificationID, userID int) error {
+	res, err := db.Exec(`UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+              WHERE id = $1 AND user_id = $2`, notificationID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
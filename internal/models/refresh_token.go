@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken is a stored refresh token. Only the SHA-256 hash of the token
+// is kept. Tokens handed out by successive refreshes of one login share a
+// FamilyID, which is the ID of the login's Session.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (t *RefreshToken) Create(db *sql.DB) error {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+              VALUES ($1, $2, $3, $4)
+              RETURNING id, created_at`
+	return db.QueryRow(query, t.UserID, t.FamilyID, t.TokenHash, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+}
+
+func GetRefreshTokenByHash(db *sql.DB, tokenHash string) (*RefreshToken, error) {
+	t := &RefreshToken{}
+	query := `SELECT id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at
+              FROM refresh_tokens WHERE token_hash = $1`
+	err := db.QueryRow(query, tokenHash).Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash,
+		&t.CreatedAt, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// UseRefreshToken marks a token used. It returns sql.ErrNoRows if the token
+// was already used, revoked or has expired, including when a concurrent
+// request used it first, so only one refresh can ever succeed per token.
+func UseRefreshToken(db *sql.DB, tokenID int) error {
+	query := `UPDATE refresh_tokens SET used_at = NOW()
+              WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`
+	res, err := db.Exec(query, tokenID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredRefreshTokens removes tokens that expired before cutoff and
+// reports how many were removed.
+func DeleteExpiredRefreshTokens(db *sql.DB, cutoff time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateName is returned when a user already has an item with the
+// requested name.
+var ErrDuplicateName = errors.New("name already in use")
+
+// SavedSearchQuery holds the same parameters accepted by the file listing and
+// search endpoints. Dates are kept as entered so that relative periods like
+// CreatedWithin "30d" are resolved each time the search runs.
+type SavedSearchQuery struct {
+	Q             string   `json:"q,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	Type          string   `json:"type,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	MinSize       int64    `json:"min_size,omitempty"`
+	MaxSize       int64    `json:"max_size,omitempty"`
+	CreatedAfter  string   `json:"created_after,omitempty"`
+	CreatedBefore string   `json:"created_before,omitempty"`
+	CreatedWithin string   `json:"created_within,omitempty"`
+	Sort          string   `json:"sort,omitempty"`
+	Order         string   `json:"order,omitempty"`
+}
+
+type SavedSearch struct {
+	ID        int              `json:"id"`
+	UserID    int              `json:"user_id"`
+	Name      string           `json:"name"`
+	Query     SavedSearchQuery `json:"query"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func scanSavedSearch(row rowScanner) (*SavedSearch, error) {
+	s := &SavedSearch{}
+	var query []byte
+	if err := row.Scan(&s.ID, &s.UserID, &s.Name, &query, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(query, &s.Query); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SavedSearch) Create(db *sql.DB) error {
+	query, err := json.Marshal(s.Query)
+	if err != nil {
+		return err
+	}
+	err = db.QueryRow(`INSERT INTO saved_searches (user_id, name, query) VALUES ($1, $2, $3) 
+              RETURNING id, created_at, updated_at`,
+		s.UserID, s.Name, query).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func (s *SavedSearch) Update(db *sql.DB) error {
+	query, err := json.Marshal(s.Query)
+	if err != nil {
+		return err
+	}
+	err = db.QueryRow(`UPDATE saved_searches SET name = $1, query = $2, updated_at = NOW() 
+              WHERE id = $3 AND user_id = $4 RETURNING updated_at`,
+		s.Name, query, s.ID, s.UserID).Scan(&s.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+func GetSavedSearchByID(db *sql.DB, id, userID int) (*SavedSearch, error) {
+	query := `SELECT id, user_id, name, query, created_at, updated_at 
+              FROM saved_searches WHERE id = $1 AND user_id = $2`
+	return scanSavedSearch(db.QueryRow(query, id, userID))
+}
+
+func GetSavedSearchesByUser(db *sql.DB, userID int) ([]*SavedSearch, error) {
+	query := `SELECT id, user_id, name, query, created_at, updated_at 
+              FROM saved_searches WHERE user_id = $1 ORDER BY name`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []*SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// DeleteSavedSearch returns sql.ErrNoRows if the user has no such search.
+func DeleteSavedSearch(db *sql.DB, id, userID int) error {
+	res, err := db.Exec(`DELETE FROM saved_searches WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
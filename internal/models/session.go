@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Session is one login on one device, lasting as long as its refresh tokens
+// are renewed. Its ID doubles as the refresh token family ID.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at`
+
+func scanSession(row rowScanner) (*Session, error) {
+	s := &Session{}
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) Create(db *sql.DB) error {
+	query := `INSERT INTO sessions (id, user_id, user_agent, ip)
+              VALUES ($1, $2, $3, $4)
+              RETURNING created_at, last_seen_at`
+	return db.QueryRow(query, s.ID, s.UserID, s.UserAgent, s.IP).Scan(&s.CreatedAt, &s.LastSeenAt)
+}
+
+// TouchSession records that a session was just used from ip with userAgent.
+func TouchSession(db *sql.DB, sessionID, userAgent, ip string) error {
+	query := `UPDATE sessions SET last_seen_at = NOW(), user_agent = $1, ip = $2 WHERE id = $3`
+	_, err := db.Exec(query, userAgent, ip, sessionID)
+	return err
+}
+
+// GetActiveSessions lists the user's sessions that haven't been revoked and
+// still hold a usable refresh token, most recently used first.
+func GetActiveSessions(db *sql.DB, userID int) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+              WHERE user_id = $1 AND revoked_at IS NULL
+              AND EXISTS (SELECT 1 FROM refresh_tokens
+                          WHERE refresh_tokens.family_id = sessions.id AND refresh_tokens.used_at IS NULL
+                          AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > NOW())
+              ORDER BY last_seen_at DESC, created_at DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSessions ends the given sessions of a user together with their
+// refresh tokens and returns the IDs of those that were still active. With
+// no sessionIDs every session of the user is revoked except keep, which may
+// be empty.
+func RevokeSessions(db *sql.DB, userID int, sessionIDs []string, keep string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE sessions SET revoked_at = NOW()
+              WHERE user_id = $1 AND revoked_at IS NULL AND id <> $2
+              AND (COALESCE(CARDINALITY($3::TEXT[]), 0) = 0 OR id = ANY($3))
+              RETURNING id`
+	rows, err := tx.Query(query, userID, keep, pq.Array(sessionIDs))
+	if err != nil {
+		return nil, err
+	}
+	var revoked []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		revoked = append(revoked, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(revoked) > 0 {
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW()
+              WHERE family_id = ANY($1) AND revoked_at IS NULL`, pq.Array(revoked))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revoked, tx.Commit()
+}
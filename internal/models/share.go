@@ -0,0 +1,343 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// What a share link points at.
+const (
+	ShareKindFile      = "file"
+	ShareKindFolder    = "folder"
+	ShareKindSelection = "selection"
+)
+
+const (
+	SharePermissionView     = "view"
+	SharePermissionDownload = "download"
+)
+
+const (
+	ShareStatusActive    = "active"
+	ShareStatusExpired   = "expired"
+	ShareStatusRevoked   = "revoked"
+	ShareStatusExhausted = "exhausted"
+)
+
+// ErrShareUnavailable is returned when a share can no longer serve a download
+// because it expired, was revoked or used up its download limit.
+var ErrShareUnavailable = errors.New("share is no longer available")
+
+// Share is a public link to a file (FileID), a folder and everything below it
+// (FolderID), or, with neither set, a selection of files kept in share_files.
+// PasswordHash is a bcrypt hash, empty for links that don't need a password.
+// MaxDownloads is nil for unlimited links.
+type Share struct {
+	ID            int        `json:"id"`
+	Token         string     `json:"token"`
+	FileID        *int       `json:"file_id"`
+	FolderID      *int       `json:"folder_id"`
+	CreatedBy     int        `json:"created_by"`
+	Label         string     `json:"label"`
+	Permission    string     `json:"permission"`
+	PasswordHash  string     `json:"-"`
+	MaxDownloads  *int       `json:"max_downloads"`
+	DownloadCount int        `json:"download_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+// Kind reports what the share points at: a file, a folder or a selection.
+func (s *Share) Kind() string {
+	switch {
+	case s.FileID != nil:
+		return ShareKindFile
+	case s.FolderID != nil:
+		return ShareKindFolder
+	}
+	return ShareKindSelection
+}
+
+// Status reports whether the share can still be used at time now.
+func (s *Share) Status(now time.Time) string {
+	switch {
+	case s.RevokedAt != nil:
+		return ShareStatusRevoked
+	case s.ExpiresAt != nil && !s.ExpiresAt.After(now):
+		return ShareStatusExpired
+	case s.MaxDownloads != nil && s.DownloadCount >= *s.MaxDownloads:
+		return ShareStatusExhausted
+	}
+	return ShareStatusActive
+}
+
+// RemainingDownloads returns how many more downloads the share allows, nil
+// if it is unlimited.
+func (s *Share) RemainingDownloads() *int {
+	if s.MaxDownloads == nil {
+		return nil
+	}
+	remaining := *s.MaxDownloads - s.DownloadCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &remaining
+}
+
+const shareColumns = `shares.id, shares.token, shares.file_id, shares.folder_id, shares.created_by, shares.label,
+              shares.permission, shares.password_hash, shares.max_downloads, shares.download_count,
+              shares.created_at, shares.expires_at, shares.revoked_at`
+
+func shareDest(s *Share) []interface{} {
+	return []interface{}{
+		&s.ID, &s.Token, &s.FileID, &s.FolderID, &s.CreatedBy, &s.Label,
+		&s.Permission, &s.PasswordHash, &s.MaxDownloads, &s.DownloadCount,
+		&s.CreatedAt, &s.ExpiresAt, &s.RevokedAt,
+	}
+}
+
+func scanShares(rows *sql.Rows) ([]*Share, error) {
+	defer rows.Close()
+
+	var shares []*Share
+	for rows.Next() {
+		s := &Share{}
+		if err := rows.Scan(shareDest(s)...); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *Share) insert(q rowQueryer) error {
+	query := `INSERT INTO shares (token, file_id, folder_id, created_by, label, permission, password_hash, max_downloads, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+              RETURNING id, created_at`
+	return q.QueryRow(query, s.Token, s.FileID, s.FolderID, s.CreatedBy, s.Label, s.Permission, s.PasswordHash,
+		s.MaxDownloads, s.ExpiresAt).Scan(&s.ID, &s.CreatedAt)
+}
+
+// Create saves a file or folder share.
+func (s *Share) Create(db *sql.DB) error {
+	return s.insert(db)
+}
+
+// CreateSelection saves a share of the given files. Every file must belong
+// to the share's creator; otherwise nothing is saved and sql.ErrNoRows is
+// returned.
+func (s *Share) CreateSelection(db *sql.DB, fileIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.insert(tx); err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(fileIDs))
+	for i, id := range fileIDs {
+		ids[i] = int64(id)
+	}
+	res, err := tx.Exec(`INSERT INTO share_files (share_id, file_id)
+              SELECT $1, id FROM files WHERE id = ANY($2) AND user_id = $3`,
+		s.ID, pq.Array(ids), s.CreatedBy)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != int64(len(fileIDs)) {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// GetSharesByFile lists every share link of a file owned by userID, newest
+// first, including expired and revoked ones.
+func GetSharesByFile(db *sql.DB, fileID, userID int) ([]*Share, error) {
+	query := `SELECT ` + shareColumns + `
+              FROM shares JOIN files ON files.id = shares.file_id
+              WHERE shares.file_id = $1 AND files.user_id = $2
+              ORDER BY shares.created_at DESC, shares.id DESC`
+	rows, err := db.Query(query, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanShares(rows)
+}
+
+// GetSharesByFolder lists every share link of a folder owned by userID,
+// newest first.
+func GetSharesByFolder(db *sql.DB, folderID, userID int) ([]*Share, error) {
+	query := `SELECT ` + shareColumns + `
+              FROM shares
+              WHERE shares.folder_id = $1 AND shares.created_by = $2
+              ORDER BY shares.created_at DESC, shares.id DESC`
+	rows, err := db.Query(query, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanShares(rows)
+}
+
+// GetSharesByUser lists every share link the user created, of any kind,
+// newest first.
+func GetSharesByUser(db *sql.DB, userID int) ([]*Share, error) {
+	query := `SELECT ` + shareColumns + `
+              FROM shares
+              WHERE shares.created_by = $1
+              ORDER BY shares.created_at DESC, shares.id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanShares(rows)
+}
+
+// GetShareByOwner loads any share link the user created.
+func GetShareByOwner(db *sql.DB, shareID, userID int) (*Share, error) {
+	s := &Share{}
+	query := `SELECT ` + shareColumns + ` FROM shares WHERE shares.id = $1 AND shares.created_by = $2`
+	if err := db.QueryRow(query, shareID, userID).Scan(shareDest(s)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetShareByID loads a share link of a file owned by userID.
+func GetShareByID(db *sql.DB, shareID, fileID, userID int) (*Share, error) {
+	s := &Share{}
+	query := `SELECT ` + shareColumns + `
+              FROM shares JOIN files ON files.id = shares.file_id
+              WHERE shares.id = $1 AND shares.file_id = $2 AND files.user_id = $3`
+	if err := db.QueryRow(query, shareID, fileID, userID).Scan(shareDest(s)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RevokeShare disables a share link immediately. The row is kept so the owner
+// can still see it in their share lists.
+func RevokeShare(db *sql.DB, shareID, userID int) error {
+	query := `UPDATE shares SET revoked_at = NOW()
+              WHERE id = $1 AND created_by = $2 AND revoked_at IS NULL`
+	res, err := db.Exec(query, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetShareByToken resolves a share token whatever the share's status, so
+// callers can tell an expired link from a wrong one.
+func GetShareByToken(db *sql.DB, token string) (*Share, error) {
+	s := &Share{}
+	query := `SELECT ` + shareColumns + ` FROM shares WHERE shares.token = $1`
+	if err := db.QueryRow(query, token).Scan(shareDest(s)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetActiveShare resolves a share token as long as the share has neither
+// expired nor been revoked.
+func GetActiveShare(db *sql.DB, token string) (*Share, error) {
+	s := &Share{}
+	query := `SELECT ` + shareColumns + ` FROM shares
+              WHERE shares.token = $1 AND shares.revoked_at IS NULL
+              AND (shares.expires_at IS NULL OR shares.expires_at > NOW())`
+	if err := db.QueryRow(query, token).Scan(shareDest(s)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// shareEntriesQuery selects fileColumns and the FileEntry path of every file a
+// share covers. For folder shares the tree below the folder is walked, so
+// files added later are included.
+const shareEntriesQuery = `WITH RECURSIVE tree(id, path, seen) AS (
+                  SELECT id, ''::TEXT, ARRAY[id] FROM folders WHERE id = $2
+                  UNION ALL
+                  SELECT folders.id, tree.path || folders.name || '/', tree.seen || folders.id
+                  FROM folders JOIN tree ON folders.parent_id = tree.id
+                  WHERE folders.id <> ALL(tree.seen)
+              ), entries(file_id, path) AS (
+                  SELECT files.id, files.name::TEXT FROM files WHERE files.id = $1
+                  UNION ALL
+                  SELECT files.id, tree.path || files.name FROM files JOIN tree ON files.folder_id = tree.id
+                  UNION ALL
+                  SELECT share_files.file_id, files.name::TEXT FROM share_files
+                  JOIN files ON files.id = share_files.file_id
+                  WHERE share_files.share_id = $3 AND $1::INTEGER IS NULL AND $2::INTEGER IS NULL
+              )
+              SELECT ` + fileColumns + `, entries.path
+              FROM entries JOIN files ON files.id = entries.file_id
+              WHERE files.user_id = $4`
+
+// GetShareEntries lists the files a share gives access to, ordered by path.
+func GetShareEntries(db *sql.DB, s *Share) ([]*FileEntry, error) {
+	rows, err := db.Query(shareEntriesQuery+` ORDER BY entries.path, files.id`,
+		s.FileID, s.FolderID, s.ID, s.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return scanFileEntries(rows)
+}
+
+// GetShareEntry loads one file covered by a share, sql.ErrNoRows if the share
+// doesn't include it.
+func GetShareEntry(db *sql.DB, s *Share, fileID int) (*FileEntry, error) {
+	e := &FileEntry{}
+	f, err := scanFile(db.QueryRow(shareEntriesQuery+` AND files.id = $5 LIMIT 1`,
+		s.FileID, s.FolderID, s.ID, s.CreatedBy, fileID), &e.Path)
+	if err != nil {
+		return nil, err
+	}
+	e.File = f
+	return e, nil
+}
+
+// UpdateShare saves a share's label, password, download limit and expiry.
+// Revoked shares can't be changed.
+func UpdateShare(db *sql.DB, s *Share) error {
+	query := `UPDATE shares SET label = $1, password_hash = $2, max_downloads = $3, expires_at = $4
+              WHERE id = $5 AND revoked_at IS NULL`
+	res, err := db.Exec(query, s.Label, s.PasswordHash, s.MaxDownloads, s.ExpiresAt, s.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ConsumeShareDownload counts one download against the share. The check and
+// the increment happen in a single conditional UPDATE, so concurrent requests
+// can never push a link past its limit; the request that would is refused
+// with ErrShareUnavailable.
+func ConsumeShareDownload(db *sql.DB, s *Share) error {
+	query := `UPDATE shares SET download_count = download_count + 1
+              WHERE id = $1 AND revoked_at IS NULL
+              AND (expires_at IS NULL OR expires_at > NOW())
+              AND (max_downloads IS NULL OR download_count < max_downloads)
+              RETURNING download_count`
+	err := db.QueryRow(query, s.ID).Scan(&s.DownloadCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrShareUnavailable
+	}
+	return err
+}
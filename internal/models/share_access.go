@@ -0,0 +1,208 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// What a share link request asked for.
+const (
+	ShareActionPage     = "page"
+	ShareActionPreview  = "preview"
+	ShareActionDownload = "download"
+	ShareActionUnlock   = "unlock"
+)
+
+// How a share link request ended. Requests to unusable links are logged with
+// the share's status (expired, revoked or exhausted) as their outcome.
+const (
+	ShareOutcomeOK        = "ok"
+	ShareOutcomeLocked    = "locked"
+	ShareOutcomeDenied    = "denied"
+	ShareOutcomeThrottled = "throttled"
+	ShareOutcomeError     = "error"
+)
+
+// ShareAccess is one entry of a share's access log.
+type ShareAccess struct {
+	ID          int64     `json:"id"`
+	ShareID     int       `json:"share_id"`
+	FileID      *int      `json:"file_id"`
+	Action      string    `json:"action"`
+	Outcome     string    `json:"outcome"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	BytesServed int64     `json:"bytes_served"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (a *ShareAccess) Create(db *sql.DB) error {
+	query := `INSERT INTO share_access_log (share_id, file_id, action, outcome, ip, user_agent, bytes_served)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)
+              RETURNING id, created_at`
+	return db.QueryRow(query, a.ShareID, a.FileID, a.Action, a.Outcome, a.IP, a.UserAgent,
+		a.BytesServed).Scan(&a.ID, &a.CreatedAt)
+}
+
+// GetShareAccesses returns a share's log entries newest first. before is the
+// ID of the oldest entry already seen, 0 for the first page.
+func GetShareAccesses(db *sql.DB, shareID int, before int64, limit int) ([]*ShareAccess, error) {
+	query := `SELECT id, share_id, file_id, action, outcome, ip, user_agent, bytes_served, created_at
+              FROM share_access_log
+              WHERE share_id = $1 AND ($2 = 0 OR id < $2)
+              ORDER BY id DESC LIMIT $3`
+	rows, err := db.Query(query, shareID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accesses []*ShareAccess
+	for rows.Next() {
+		a := &ShareAccess{}
+		if err := rows.Scan(&a.ID, &a.ShareID, &a.FileID, &a.Action, &a.Outcome, &a.IP, &a.UserAgent,
+			&a.BytesServed, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, a)
+	}
+	return accesses, rows.Err()
+}
+
+// DeleteShareAccessesBefore removes log entries made before cutoff and
+// returns how many there were.
+func DeleteShareAccessesBefore(db *sql.DB, cutoff time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM share_access_log WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ShareAccessCounts aggregates log entries. Views, Previews and Downloads
+// count successful requests only; Denied counts every unsuccessful one.
+type ShareAccessCounts struct {
+	Views       int64 `json:"views"`
+	Previews    int64 `json:"previews"`
+	Downloads   int64 `json:"downloads"`
+	Denied      int64 `json:"denied"`
+	BytesServed int64 `json:"bytes_served"`
+}
+
+type ShareAccessPeriod struct {
+	Period time.Time `json:"period"`
+	ShareAccessCounts
+}
+
+// ShareAccessStats summarises the accesses of one share, or all file shares
+// of a file, between two points in time. UniqueVisitors counts the addresses
+// of successful requests. Series has one entry per interval, including empty
+// ones.
+type ShareAccessStats struct {
+	ShareAccessCounts
+	UniqueVisitors int64               `json:"unique_visitors"`
+	LastAccessedAt *time.Time          `json:"last_accessed_at"`
+	Outcomes       map[string]int64    `json:"outcomes"`
+	Series         []ShareAccessPeriod `json:"series"`
+}
+
+const shareAccessCountColumns = `COUNT(l.id) FILTER (WHERE l.action = 'page' AND l.outcome = 'ok'),
+              COUNT(l.id) FILTER (WHERE l.action = 'preview' AND l.outcome = 'ok'),
+              COUNT(l.id) FILTER (WHERE l.action = 'download' AND l.outcome = 'ok'),
+              COUNT(l.id) FILTER (WHERE l.outcome <> 'ok'),
+              COALESCE(SUM(l.bytes_served), 0)`
+
+func shareAccessCountDest(c *ShareAccessCounts) []interface{} {
+	return []interface{}{&c.Views, &c.Previews, &c.Downloads, &c.Denied, &c.BytesServed}
+}
+
+// GetShareAccessStats aggregates the log over [from, to) of the links sharing
+// fileID on its own, of shareID, or of shareID if it is one of them; at least
+// one of the two must be given. interval is a PostgreSQL date_trunc unit:
+// hour, day, week or month.
+func GetShareAccessStats(db *sql.DB, fileID, shareID *int, from, to time.Time, interval string) (*ShareAccessStats, error) {
+	const where = `($1::INTEGER IS NULL OR l.share_id IN (SELECT id FROM shares WHERE shares.file_id = $1))
+              AND ($2::INTEGER IS NULL OR l.share_id = $2)
+              AND l.created_at >= $3 AND l.created_at < $4`
+
+	stats := &ShareAccessStats{Outcomes: map[string]int64{}}
+	dest := append(shareAccessCountDest(&stats.ShareAccessCounts), &stats.UniqueVisitors, &stats.LastAccessedAt)
+	err := db.QueryRow(`SELECT `+shareAccessCountColumns+`,
+              COUNT(DISTINCT l.ip) FILTER (WHERE l.outcome = 'ok'), MAX(l.created_at) FILTER (WHERE l.outcome = 'ok')
+              FROM share_access_log l WHERE `+where, fileID, shareID, from, to).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT l.outcome, COUNT(*) FROM share_access_log l WHERE `+where+`
+              GROUP BY l.outcome`, fileID, shareID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var outcome string
+		var n int64
+		if err := rows.Scan(&outcome, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.Outcomes[outcome] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT periods.period, `+shareAccessCountColumns+`
+              FROM generate_series(date_trunc($5::TEXT, $3::TIMESTAMP), $4::TIMESTAMP - INTERVAL '1 microsecond',
+                                   ('1 ' || $5::TEXT)::INTERVAL) AS periods(period)
+              LEFT JOIN share_access_log l ON `+where+` AND date_trunc($5::TEXT, l.created_at) = periods.period
+              GROUP BY periods.period ORDER BY periods.period`, fileID, shareID, from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats.Series = []ShareAccessPeriod{}
+	for rows.Next() {
+		var p ShareAccessPeriod
+		if err := rows.Scan(append([]interface{}{&p.Period}, shareAccessCountDest(&p.ShareAccessCounts)...)...); err != nil {
+			return nil, err
+		}
+		stats.Series = append(stats.Series, p)
+	}
+	return stats, rows.Err()
+}
+
+// ShareAccessTotals is one share's counts in a file-wide summary.
+type ShareAccessTotals struct {
+	ShareID int    `json:"share_id"`
+	Label   string `json:"label"`
+	ShareAccessCounts
+}
+
+// GetShareAccessTotalsByShare breaks a file's accesses over [from, to) down
+// by share, covering every share of the file even without accesses.
+func GetShareAccessTotalsByShare(db *sql.DB, fileID int, from, to time.Time) ([]ShareAccessTotals, error) {
+	rows, err := db.Query(`SELECT shares.id, shares.label, `+shareAccessCountColumns+`
+              FROM shares
+              LEFT JOIN share_access_log l ON l.share_id = shares.id
+                  AND l.created_at >= $2 AND l.created_at < $3
+              WHERE shares.file_id = $1
+              GROUP BY shares.id, shares.label
+              ORDER BY shares.created_at DESC, shares.id DESC`, fileID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []ShareAccessTotals{}
+	for rows.Next() {
+		var t ShareAccessTotals
+		if err := rows.Scan(append([]interface{}{&t.ShareID, &t.Label}, shareAccessCountDest(&t.ShareAccessCounts)...)...); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TwoFactor is a user's TOTP state. A Secret without EnabledAt is an
+// enrollment that hasn't been confirmed with a code yet.
+type TwoFactor struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  int64
+}
+
+func (t *TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+func (t *TwoFactor) Pending() bool {
+	return t.Secret != "" && t.EnabledAt == nil
+}
+
+func GetTwoFactor(db *sql.DB, userID int) (*TwoFactor, error) {
+	t := &TwoFactor{UserID: userID}
+	query := `SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1`
+	if err := db.QueryRow(query, userID).Scan(&t.Secret, &t.EnabledAt, &t.LastStep); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// StartTOTPEnrollment stores a new secret for the user to confirm. It fails
+// with sql.ErrNoRows if TOTP is already enabled.
+func StartTOTPEnrollment(db *sql.DB, userID int, secret string) error {
+	query := `UPDATE users SET totp_secret = $1, totp_last_step = 0, updated_at = NOW()
+              WHERE id = $2 AND totp_enabled_at IS NULL`
+	res, err := db.Exec(query, secret, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of a time step was used. It fails with
+// sql.ErrNoRows if that or a later step was used already, i.e. the code is
+// being replayed.
+func UseTOTPStep(db *sql.DB, userID int, step int64) error {
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`
+	res, err := db.Exec(query, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EnableTOTP completes an enrollment and replaces the user's recovery codes.
+func EnableTOTP(db *sql.DB, userID int, recoveryCodeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE users SET totp_enabled_at = NOW(), updated_at = NOW()
+                         WHERE id = $1 AND totp_secret <> '' AND totp_enabled_at IS NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP turns two-factor authentication off and forgets the secret and
+// recovery codes.
+func DisableTOTP(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+                      WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	query := `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, UNNEST($2::TEXT[])`
+	_, err := tx.Exec(query, userID, pq.Array(hashes))
+	return err
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes in favour of new
+// ones.
+func ReplaceRecoveryCodes(db *sql.DB, userID int, hashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks a recovery code used. It fails with sql.ErrNoRows if
+// the user has no such unused code.
+func UseRecoveryCode(db *sql.DB, userID int, hash string) error {
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	res, err := db.Exec(query, userID, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func CountUnusedRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err := db.QueryRow(query, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	UploadRequestStatusActive  = "active"
+	UploadRequestStatusExpired = "expired"
+	UploadRequestStatusRevoked = "revoked"
+	UploadRequestStatusFull    = "full"
+)
+
+// ErrUploadRequestFull is returned when an upload request can't take the
+// files offered, because it reached its file limit, expired or was revoked.
+var ErrUploadRequestFull = errors.New("upload request cannot accept more files")
+
+// UploadRequest is a link through which anyone holding it can upload files
+// into FolderID of the owner. AllowedTypes holds MIME types ("application/pdf"),
+// major types ("image/*") or extensions (".docx"); empty allows any file.
+// MaxFiles and MaxFileSize are nil when unlimited.
+type UploadRequest struct {
+	ID           int        `json:"id"`
+	Token        string     `json:"token"`
+	UserID       int        `json:"user_id"`
+	FolderID     int        `json:"folder_id"`
+	Label        string     `json:"label"`
+	Message      string     `json:"message"`
+	PasswordHash string     `json:"-"`
+	AllowedTypes []string   `json:"allowed_types"`
+	MaxFiles     *int       `json:"max_files"`
+	MaxFileSize  *int64     `json:"max_file_size"`
+	FileCount    int        `json:"file_count"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+// Status reports whether the request still accepts uploads at time now.
+func (u *UploadRequest) Status(now time.Time) string {
+	switch {
+	case u.RevokedAt != nil:
+		return UploadRequestStatusRevoked
+	case u.ExpiresAt != nil && !u.ExpiresAt.After(now):
+		return UploadRequestStatusExpired
+	case u.MaxFiles != nil && u.FileCount >= *u.MaxFiles:
+		return UploadRequestStatusFull
+	}
+	return UploadRequestStatusActive
+}
+
+// RemainingFiles returns how many more files the request accepts, nil if it
+// is unlimited.
+func (u *UploadRequest) RemainingFiles() *int {
+	if u.MaxFiles == nil {
+		return nil
+	}
+	remaining := *u.MaxFiles - u.FileCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &remaining
+}
+
+const uploadRequestColumns = `id, token, user_id, folder_id, label, message, password_hash, allowed_types,
+              max_files, max_file_size, file_count, created_at, expires_at, revoked_at`
+
+func scanUploadRequest(row rowScanner) (*UploadRequest, error) {
+	u := &UploadRequest{}
+	err := row.Scan(&u.ID, &u.Token, &u.UserID, &u.FolderID, &u.Label, &u.Message, &u.PasswordHash,
+		pq.Array(&u.AllowedTypes), &u.MaxFiles, &u.MaxFileSize, &u.FileCount,
+		&u.CreatedAt, &u.ExpiresAt, &u.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *UploadRequest) Create(db *sql.DB) error {
+	if u.AllowedTypes == nil {
+		u.AllowedTypes = []string{}
+	}
+	query := `INSERT INTO upload_requests (token, user_id, folder_id, label, message, password_hash,
+                  allowed_types, max_files, max_file_size, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+              RETURNING id, created_at`
+	return db.QueryRow(query, u.Token, u.UserID, u.FolderID, u.Label, u.Message, u.PasswordHash,
+		pq.Array(u.AllowedTypes), u.MaxFiles, u.MaxFileSize, u.ExpiresAt).Scan(&u.ID, &u.CreatedAt)
+}
+
+// GetUploadRequestsByUser lists the user's upload requests, newest first,
+// including expired and revoked ones.
+func GetUploadRequestsByUser(db *sql.DB, userID int) ([]*UploadRequest, error) {
+	query := `SELECT ` + uploadRequestColumns + ` FROM upload_requests
+              WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []*UploadRequest
+	for rows.Next() {
+		u, err := scanUploadRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, u)
+	}
+	return requests, rows.Err()
+}
+
+func GetUploadRequestByID(db *sql.DB, requestID, userID int) (*UploadRequest, error) {
+	query := `SELECT ` + uploadRequestColumns + ` FROM upload_requests WHERE id = $1 AND user_id = $2`
+	return scanUploadRequest(db.QueryRow(query, requestID, userID))
+}
+
+// GetUploadRequestByToken resolves a token whatever the request's status.
+func GetUploadRequestByToken(db *sql.DB, token string) (*UploadRequest, error) {
+	query := `SELECT ` + uploadRequestColumns + ` FROM upload_requests WHERE token = $1`
+	return scanUploadRequest(db.QueryRow(query, token))
+}
+
+func RevokeUploadRequest(db *sql.DB, requestID, userID int) error {
+	res, err := db.Exec(`UPDATE upload_requests SET revoked_at = NOW()
+              WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, requestID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeUploadRequestsByUser revokes every open upload request of the user.
+func RevokeUploadRequestsByUser(db *sql.DB, userID int) error {
+	_, err := db.Exec(`UPDATE upload_requests SET revoked_at = NOW()
+              WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+// ReserveUploadSlots claims room for n files before they are stored. Like
+// ConsumeShareDownload, the check and the increment are one conditional
+// UPDATE so concurrent uploads can't exceed MaxFiles.
+func ReserveUploadSlots(db *sql.DB, u *UploadRequest, n int) error {
+	query := `UPDATE upload_requests SET file_count = file_count + $2
+              WHERE id = $1 AND revoked_at IS NULL
+              AND (expires_at IS NULL OR expires_at > NOW())
+              AND (max_files IS NULL OR file_count + $2 <= max_files)
+              RETURNING file_count`
+	err := db.QueryRow(query, u.ID, n).Scan(&u.FileCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUploadRequestFull
+	}
+	return err
+}
+
+// ReleaseUploadSlots gives back slots reserved for files that failed to
+// store.
+func ReleaseUploadSlots(db *sql.DB, u *UploadRequest, n int) error {
+	return db.QueryRow(`UPDATE upload_requests SET file_count = GREATEST(file_count - $2, 0)
+              WHERE id = $1 RETURNING file_count`, u.ID, n).Scan(&u.FileCount)
+}
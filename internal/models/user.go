@@ -2,28 +2,177 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 )
 
 type User struct {
-	ID           int       `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	PasswordHash    string     `json:"-"`
+	DisplayName     string     `json:"display_name"`
+	AvatarURL       string     `json:"avatar_url"`
+	Locale          string     `json:"locale"`
+	Timezone        string     `json:"timezone"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
-func (u *User) Create(db *sql.DB) error {
-	query := `INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id, created_at, updated_at`
-	return db.QueryRow(query, u.Email, u.PasswordHash).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
-}
+const userColumns = `id, email, password_hash, display_name, avatar_url, locale, timezone, email_verified_at, created_at, updated_at`
 
-func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+func scanUser(row rowScanner) (*User, error) {
 	u := &User{}
-	query := `SELECT id, email, password_hash, created_at, updated_at FROM users WHERE email = $1`
-	err := db.QueryRow(query, email).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt)
+	err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.DisplayName, &u.AvatarURL, &u.Locale, &u.Timezone, &u.EmailVerifiedAt, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
+
+// ErrEmailTaken is returned when another account already has the email.
+var ErrEmailTaken = errors.New("email is already in use")
+
+func (u *User) Create(db *sql.DB) error {
+	query := `INSERT INTO users (email, password_hash, display_name) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
+	err := db.QueryRow(query, u.Email, u.PasswordHash, u.DisplayName).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// SetEmailVerified marks the user's email verified, provided it is still the
+// address that was verified. It fails with sql.ErrNoRows otherwise.
+func SetEmailVerified(db *sql.DB, userID int, email string) error {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+              WHERE id = $1 AND email = $2`
+	res, err := db.Exec(query, userID, email)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateProfile stores the user's display name, avatar, locale and time
+// zone.
+func UpdateProfile(db *sql.DB, u *User) error {
+	query := `UPDATE users SET display_name = $1, avatar_url = $2, locale = $3, timezone = $4, updated_at = NOW()
+              WHERE id = $5 RETURNING updated_at`
+	return db.QueryRow(query, u.DisplayName, u.AvatarURL, u.Locale, u.Timezone, u.ID).Scan(&u.UpdatedAt)
+}
+
+// ChangeEmail gives the user a new, verified email address. It fails with
+// ErrEmailTaken if another account has it by now.
+func ChangeEmail(db *sql.DB, userID int, email string) error {
+	query := `UPDATE users SET email = $1, email_verified_at = NOW(), updated_at = NOW() WHERE id = $2`
+	res, err := db.Exec(query, email, userID)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser deletes the account; everything it owns goes with it through
+// ON DELETE CASCADE. The stored contents of its files don't, so it returns
+// where they are, for deleting them from storage. The files are deleted in
+// the same transaction as the account, so a file added meanwhile is either
+// returned or fails to be created.
+func DeleteUser(db *sql.DB, userID int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM files WHERE user_id = $1
+              RETURNING COALESCE(NULLIF(s3_url, ''), local_path, '')`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return urls, tx.Commit()
+}
+
+func SetPasswordHash(db *sql.DB, userID int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`
+	_, err := db.Exec(query, passwordHash, userID)
+	return err
+}
+
+// UpgradePasswordHash replaces a password hash with a stronger hash of the
+// same password, unless the password was changed in the meantime.
+func UpgradePasswordHash(db *sql.DB, userID int, oldHash, newHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`
+	_, err := db.Exec(query, newHash, userID, oldHash)
+	return err
+}
+
+func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	return scanUser(db.QueryRow(query, email))
+}
+
+func GetUserByID(db *sql.DB, userID int) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(db.QueryRow(query, userID))
+}
+
+// GetDefaultShareExpiry returns the user's preferred lifetime for new share
+// links, nil if they have none. A zero duration means never-expiring links.
+func GetDefaultShareExpiry(db *sql.DB, userID int) (*time.Duration, error) {
+	var seconds sql.NullInt64
+	query := `SELECT default_share_expiry FROM users WHERE id = $1`
+	if err := db.QueryRow(query, userID).Scan(&seconds); err != nil {
+		return nil, err
+	}
+	if !seconds.Valid {
+		return nil, nil
+	}
+	d := time.Duration(seconds.Int64) * time.Second
+	return &d, nil
+}
+
+// SetDefaultShareExpiry stores the user's preferred share lifetime; nil clears
+// it. It is stored in whole seconds, rounded up so that a short lifetime
+// doesn't turn into never-expiring links.
+func SetDefaultShareExpiry(db *sql.DB, userID int, d *time.Duration) error {
+	var seconds sql.NullInt64
+	if d != nil {
+		seconds = sql.NullInt64{Int64: int64((*d + time.Second - 1) / time.Second), Valid: true}
+	}
+	query := `UPDATE users SET default_share_expiry = $1, updated_at = NOW() WHERE id = $2`
+	_, err := db.Exec(query, seconds, userID)
+	return err
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserIdentity links a user to an account at an OpenID Connect provider.
+type UserIdentity struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+const userIdentityColumns = `id, user_id, issuer, subject, email, created_at, last_login_at`
+
+func scanUserIdentity(row rowScanner) (*UserIdentity, error) {
+	i := &UserIdentity{}
+	err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *UserIdentity) insert(q rowQueryer) error {
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email)
+              VALUES ($1, $2, $3, $4)
+              RETURNING id, created_at, last_login_at`
+	return q.QueryRow(query, i.UserID, i.Issuer, i.Subject, i.Email).Scan(&i.ID, &i.CreatedAt, &i.LastLoginAt)
+}
+
+func (i *UserIdentity) Create(db *sql.DB) error {
+	return i.insert(db)
+}
+
+func GetUserIdentity(db *sql.DB, issuer, subject string) (*UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE issuer = $1 AND subject = $2`
+	return scanUserIdentity(db.QueryRow(query, issuer, subject))
+}
+
+// TouchUserIdentity records a login through the identity and the email the
+// provider currently reports.
+func TouchUserIdentity(db *sql.DB, identityID int, email string) error {
+	query := `UPDATE user_identities SET last_login_at = NOW(), email = $1 WHERE id = $2`
+	_, err := db.Exec(query, email, identityID)
+	return err
+}
+
+// CreateUserWithIdentity provisions a user signing in through an identity
+// provider for the first time. The user has no password, and their email is
+// verified if u.EmailVerifiedAt is set.
+func CreateUserWithIdentity(db *sql.DB, u *User, identity *UserIdentity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (email, password_hash, display_name, email_verified_at)
+              VALUES ($1, '', $2, $3) RETURNING id, created_at, updated_at`
+	if err := tx.QueryRow(query, u.Email, u.DisplayName, u.EmailVerifiedAt).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return err
+	}
+	u.PasswordHash = ""
+
+	identity.UserID = u.ID
+	if err := identity.insert(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Purposes of tokens sent to users by email.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeChangeEmail   = "change_email"
+)
+
+// UserToken is a single-use token mailed to a user. Only its hash is stored.
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+const userTokenColumns = `id, user_id, purpose, token_hash, email, created_at, expires_at, used_at`
+
+func scanUserToken(row rowScanner) (*UserToken, error) {
+	t := &UserToken{}
+	err := row.Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.Email, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *UserToken) Create(db *sql.DB) error {
+	query := `INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING id, created_at`
+	return db.QueryRow(query, t.UserID, t.Purpose, t.TokenHash, t.Email, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+}
+
+// GetUserToken returns an unused, unexpired token without using it, or
+// sql.ErrNoRows.
+func GetUserToken(db *sql.DB, purpose, tokenHash string) (*UserToken, error) {
+	query := `SELECT ` + userTokenColumns + ` FROM user_tokens
+              WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()`
+	return scanUserToken(db.QueryRow(query, tokenHash, purpose))
+}
+
+// UseUserToken marks an unused, unexpired token as used and returns it. It
+// fails with sql.ErrNoRows for unknown, used and expired tokens, so each
+// token works once even under concurrent requests.
+func UseUserToken(db *sql.DB, purpose, tokenHash string) (*UserToken, error) {
+	query := `UPDATE user_tokens SET used_at = NOW()
+              WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+              RETURNING ` + userTokenColumns
+	return scanUserToken(db.QueryRow(query, tokenHash, purpose))
+}
+
+// GetPendingEmailChange returns the newest outstanding email change of the
+// user, or sql.ErrNoRows.
+func GetPendingEmailChange(db *sql.DB, userID int) (*UserToken, error) {
+	query := `SELECT ` + userTokenColumns + ` FROM user_tokens
+              WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+              ORDER BY created_at DESC LIMIT 1`
+	return scanUserToken(db.QueryRow(query, userID, TokenPurposeChangeEmail))
+}
+
+// InvalidateUserTokens uses up the user's outstanding tokens for a purpose,
+// e.g. other reset links once the password has been reset.
+func InvalidateUserTokens(db *sql.DB, userID int, purpose string) error {
+	query := `UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := db.Exec(query, userID, purpose)
+	return err
+}
+
+func DeleteExpiredUserTokens(db *sql.DB, now time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM user_tokens WHERE expires_at < $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/fakubwoy/go-file-share/internal/config"
@@ -54,10 +55,66 @@ func (l *LocalStorage) UploadFile(fileHeader *multipart.FileHeader, userID int)
 		return "", fmt.Errorf("failed to copy file: %w", err)
 	}
 
-	fileURL := fmt.Sprintf("%s/%d/%s", l.baseURL, userID, fileName)
+	fileURL := fmt.Sprintf("%s/%d/%s", l.baseURL, userID, url.PathEscape(fileName))
 	return fileURL, nil
 }
 
 func (l *LocalStorage) GeneratePresignedURL(key string, expires time.Duration) (string, error) {
 	return url.JoinPath(l.baseURL, key)
 }
+
+func (l *LocalStorage) Open(fileURL string) (io.ReadCloser, error) {
+	path, err := l.pathFromURL(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (l *LocalStorage) Delete(fileURL string) error {
+	path, err := l.pathFromURL(fileURL)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) SupportsRanges() bool {
+	return true
+}
+
+// pathFromURL maps a URL returned by UploadFile back to its location on disk,
+// refusing anything that would resolve outside baseDir. The file name in the
+// URL is path-escaped; URLs stored before that have it as uploaded, so one
+// that doesn't unescape to an existing file is taken literally.
+func (l *LocalStorage) pathFromURL(fileURL string) (string, error) {
+	rel, ok := strings.CutPrefix(fileURL, l.baseURL+"/")
+	if !ok {
+		return "", fmt.Errorf("file URL %q is not served by local storage", fileURL)
+	}
+	if unescaped, err := url.PathUnescape(rel); err == nil && unescaped != rel {
+		path, err := l.pathFromRel(unescaped)
+		if err != nil {
+			return "", fmt.Errorf("invalid file URL %q", fileURL)
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	path, err := l.pathFromRel(rel)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL %q", fileURL)
+	}
+	return path, nil
+}
+
+func (l *LocalStorage) pathFromRel(rel string) (string, error) {
+	rel = filepath.Clean(filepath.FromSlash(rel))
+	if rel == "." || filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("path %q is outside the storage directory", rel)
+	}
+	return filepath.Join(l.baseDir, rel), nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fakubwoy/go-file-share/internal/config"
+)
+
+// fileHeader returns the header of a multipart file part named name holding
+// content, as a request handler would see it.
+func fileHeader(t *testing.T, name, content string) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	mw.Close()
+
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func TestLocalStorageRoundTrip(t *testing.T) {
+	l, err := NewLocalStorage(&config.Config{LocalStorageDir: t.TempDir(), ServerPort: "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"report.txt",
+		"100% done.txt",
+		"%41.txt",
+		"a b#c?d.txt",
+		"résumé.pdf",
+	}
+	for _, name := range names {
+		fileURL, err := l.UploadFile(fileHeader(t, name, "contents of "+name), 1)
+		if err != nil {
+			t.Fatalf("%q: UploadFile: %v", name, err)
+		}
+
+		rc, err := l.Open(fileURL)
+		if err != nil {
+			t.Fatalf("%q: Open(%q): %v", name, fileURL, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != "contents of "+name {
+			t.Errorf("%q: read %q, %v", name, got, err)
+		}
+
+		if err := l.Delete(fileURL); err != nil {
+			t.Errorf("%q: Delete: %v", name, err)
+		}
+		if _, err := l.Open(fileURL); !os.IsNotExist(err) {
+			t.Errorf("%q: Open after Delete = %v, want not exist", name, err)
+		}
+	}
+}
+
+func TestLocalStorageUnescapedURL(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocalStorage(&config.Config{LocalStorageDir: dir, ServerPort: "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// URLs stored before names were escaped hold them as uploaded.
+	for _, name := range []string{"100% done-1.txt", "%41-1.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, "1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "1", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := l.Open(l.baseURL + "/1/" + name)
+		if err != nil {
+			t.Fatalf("%q: Open: %v", name, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != name {
+			t.Errorf("%q: read %q", name, got)
+		}
+	}
+}
+
+func TestLocalStorageRejectsOutsidePaths(t *testing.T) {
+	l, err := NewLocalStorage(&config.Config{LocalStorageDir: t.TempDir(), ServerPort: "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileURL := range []string{
+		l.baseURL + "/../secret",
+		l.baseURL + "/%2e%2e/secret",
+		l.baseURL + "/",
+		"https://example.com/uploads/1/x.txt",
+	} {
+		if _, err := l.Open(fileURL); err == nil || os.IsNotExist(err) {
+			t.Errorf("Open(%q) = %v, want it refused", fileURL, err)
+		}
+	}
+}
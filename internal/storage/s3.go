@@ -2,8 +2,10 @@ package storage
 
 import (
 	"fmt"
+	"io"
 	"mime/multipart"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,6 +16,7 @@ import (
 )
 
 type S3Storage struct {
+	client     *s3.S3
 	uploader   *s3manager.Uploader
 	downloader *s3manager.Downloader
 	bucket     string
@@ -29,6 +32,7 @@ func NewS3Storage(cfg *config.Config) (*S3Storage, error) {
 	}
 
 	return &S3Storage{
+		client:     s3.New(sess),
 		uploader:   s3manager.NewUploader(sess),
 		downloader: s3manager.NewDownloader(sess),
 		bucket:     cfg.S3Bucket,
@@ -55,7 +59,7 @@ func (s *S3Storage) UploadFile(fileHeader *multipart.FileHeader, userID int) (st
 		return "", fmt.Errorf("failed to upload file to S3: %w", err)
 	}
 
-	fileURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, key)
+	fileURL := s.urlPrefix() + key
 	return fileURL, nil
 }
 
@@ -80,3 +84,52 @@ func (s *S3Storage) GeneratePresignedURL(key string, expires time.Duration) (str
 
 	return urlStr, nil
 }
+
+func (s *S3Storage) Open(fileURL string) (io.ReadCloser, error) {
+	key, err := s.keyFromURL(fileURL)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(fileURL string) error {
+	key, err := s.keyFromURL(fileURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object from S3: %w", err)
+	}
+	return nil
+}
+
+// SupportsRanges is false: objects are streamed from S3 and can't seek.
+func (s *S3Storage) SupportsRanges() bool {
+	return false
+}
+
+func (s *S3Storage) urlPrefix() string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.bucket, s.region)
+}
+
+func (s *S3Storage) keyFromURL(fileURL string) (string, error) {
+	key, ok := strings.CutPrefix(fileURL, s.urlPrefix())
+	if !ok || key == "" {
+		return "", fmt.Errorf("file URL %q is not in bucket %s", fileURL, s.bucket)
+	}
+	return key, nil
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"io"
 	"mime/multipart"
 	"time"
 )
@@ -8,4 +9,13 @@ import (
 type Storage interface {
 	UploadFile(fileHeader *multipart.FileHeader, userID int) (string, error)
 	GeneratePresignedURL(key string, expires time.Duration) (string, error)
+	// Open returns the contents of a file previously stored by UploadFile,
+	// identified by the URL UploadFile returned.
+	Open(fileURL string) (io.ReadCloser, error)
+	// Delete removes a file stored by UploadFile. Deleting a file that is
+	// already gone is not an error.
+	Delete(fileURL string) error
+	// SupportsRanges reports whether Open returns an io.ReadSeeker, so that
+	// byte ranges of a file can be served.
+	SupportsRanges() bool
 }
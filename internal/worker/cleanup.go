@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/fakubwoy/go-file-share/internal/models"
 	"github.com/fakubwoy/go-file-share/internal/storage"
 )
 
@@ -13,13 +14,18 @@ type CleanupWorker struct {
 	db       *sql.DB
 	storage  storage.Storage
 	interval time.Duration
+
+	// accessLogRetention is how long share access log entries are kept,
+	// zero for good.
+	accessLogRetention time.Duration
 }
 
-func NewCleanupWorker(db *sql.DB, storage storage.Storage, interval time.Duration) *CleanupWorker {
+func NewCleanupWorker(db *sql.DB, storage storage.Storage, interval, accessLogRetention time.Duration) *CleanupWorker {
 	return &CleanupWorker{
-		db:       db,
-		storage:  storage,
-		interval: interval,
+		db:                 db,
+		storage:            storage,
+		interval:           interval,
+		accessLogRetention: accessLogRetention,
 	}
 }
 
@@ -29,6 +35,45 @@ func (w *CleanupWorker) Start() {
 
 	for range ticker.C {
 		w.cleanupExpiredFiles()
+		w.cleanupExpiredRefreshTokens()
+		w.cleanupExpiredUserTokens()
+		w.cleanupShareAccessLog()
+	}
+}
+
+func (w *CleanupWorker) cleanupShareAccessLog() {
+	if w.accessLogRetention == 0 {
+		return
+	}
+	n, err := models.DeleteShareAccessesBefore(w.db, time.Now().UTC().Add(-w.accessLogRetention))
+	if err != nil {
+		log.Printf("Failed to delete old share access log entries: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d old share access log entries", n)
+	}
+}
+
+func (w *CleanupWorker) cleanupExpiredRefreshTokens() {
+	n, err := models.DeleteExpiredRefreshTokens(w.db, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to delete expired refresh tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired refresh tokens", n)
+	}
+}
+
+func (w *CleanupWorker) cleanupExpiredUserTokens() {
+	n, err := models.DeleteExpiredUserTokens(w.db, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to delete expired email tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired email tokens", n)
 	}
 }
 
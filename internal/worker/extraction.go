@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/fakubwoy/go-file-share/internal/extract"
+	"github.com/fakubwoy/go-file-share/internal/storage"
+)
+
+// ExtractionWorker fills files.content_text for newly uploaded documents so
+// that full-text search covers their contents.
+type ExtractionWorker struct {
+	db        *sql.DB
+	storage   storage.Storage
+	interval  time.Duration
+	batchSize int
+}
+
+func NewExtractionWorker(db *sql.DB, storage storage.Storage, interval time.Duration) *ExtractionWorker {
+	return &ExtractionWorker{
+		db:        db,
+		storage:   storage,
+		interval:  interval,
+		batchSize: 20,
+	}
+}
+
+func (w *ExtractionWorker) Start() {
+	// Files claimed by an instance that died mid-batch would otherwise stay
+	// in "processing" forever.
+	if _, err := w.db.Exec(
+		"UPDATE files SET extraction_status = 'pending' WHERE extraction_status = 'processing'"); err != nil {
+		log.Printf("Failed to requeue interrupted extractions: %v", err)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Keep draining while batches come back full, so a burst of uploads
+		// is indexed without waiting a full interval per batch.
+		for w.processBatch() == w.batchSize {
+			continue
+		}
+	}
+}
+
+type extractionJob struct {
+	ID        int
+	Name      string
+	Type      string
+	S3URL     string
+	LocalPath string
+}
+
+// processBatch extracts text for up to batchSize pending files and returns how
+// many it claimed. Claiming with SKIP LOCKED lets several instances share the
+// queue without handing out the same file twice.
+func (w *ExtractionWorker) processBatch() int {
+	ctx := context.Background()
+
+	rows, err := w.db.QueryContext(ctx,
+		`UPDATE files SET extraction_status = 'processing'
+         WHERE id IN (SELECT id FROM files WHERE extraction_status = 'pending'
+                      ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+         RETURNING id, name, type, s3_url, local_path`, w.batchSize)
+	if err != nil {
+		log.Printf("Failed to claim files for extraction: %v", err)
+		return 0
+	}
+
+	var jobs []extractionJob
+	for rows.Next() {
+		var j extractionJob
+		if err := rows.Scan(&j.ID, &j.Name, &j.Type, &j.S3URL, &j.LocalPath); err != nil {
+			log.Printf("Failed to scan file: %v", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		status := "done"
+		text, err := w.extract(j)
+		switch {
+		case errors.Is(err, extract.ErrUnsupported):
+			status = "skipped"
+		case err != nil:
+			log.Printf("Failed to extract text from file %d: %v", j.ID, err)
+			status = "failed"
+		}
+
+		if _, err := w.db.ExecContext(ctx,
+			"UPDATE files SET content_text = $1, extraction_status = $2 WHERE id = $3",
+			text, status, j.ID); err != nil {
+			log.Printf("Failed to store extracted text for file %d: %v", j.ID, err)
+		}
+	}
+	return len(jobs)
+}
+
+func (w *ExtractionWorker) extract(j extractionJob) (string, error) {
+	if !extract.Supported(j.Name, j.Type) {
+		return "", extract.ErrUnsupported
+	}
+
+	fileURL := j.S3URL
+	if fileURL == "" {
+		fileURL = j.LocalPath
+	}
+	rc, err := w.storage.Open(fileURL)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	return extract.Text(rc, j.Name, j.Type)
+}
@@ -0,0 +1,7 @@
+-- Keyset pagination walks (user_id, <sort column>, id); one index per sort key
+-- keeps every page an index range scan.
+CREATE INDEX idx_files_user_name ON files(user_id, name, id);
+CREATE INDEX idx_files_user_size ON files(user_id, size, id);
+CREATE INDEX idx_files_user_created_at ON files(user_id, created_at, id);
+CREATE INDEX idx_files_user_updated_at ON files(user_id, updated_at, id);
+CREATE INDEX idx_files_user_type ON files(user_id, type);
@@ -0,0 +1,28 @@
+ALTER TABLE files
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN content_text TEXT,
+    ADD COLUMN extraction_status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    ADD COLUMN search_vector TSVECTOR;
+
+-- Names are indexed twice: as-is, and with separators turned into spaces so
+-- that "quarterly" matches "quarterly_report-final.pdf".
+CREATE FUNCTION files_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', NEW.name || ' ' || regexp_replace(NEW.name, '[_.\-]+', ' ', 'g')), 'A') ||
+        setweight(to_tsvector('english', array_to_string(NEW.tags, ' ')), 'A') ||
+        setweight(to_tsvector('english', coalesce(NEW.description, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(NEW.content_text, '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER files_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF name, description, tags, content_text ON files
+    FOR EACH ROW EXECUTE FUNCTION files_search_vector_update();
+
+UPDATE files SET name = name;
+
+CREATE INDEX idx_files_search_vector ON files USING GIN(search_vector);
+CREATE INDEX idx_files_extraction_pending ON files(id) WHERE extraction_status = 'pending';
@@ -0,0 +1,22 @@
+ALTER TABLE files
+    ADD COLUMN last_accessed_at TIMESTAMP,
+    ADD COLUMN download_count BIGINT NOT NULL DEFAULT 0;
+
+-- user_id is NULL for anonymous access through share links.
+CREATE TABLE file_access_events (
+    id BIGSERIAL PRIMARY KEY,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_file_access_events_user ON file_access_events(user_id, created_at DESC);
+CREATE INDEX idx_file_access_events_file ON file_access_events(file_id);
+
+CREATE TABLE file_stars (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, file_id)
+);
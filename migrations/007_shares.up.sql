@@ -0,0 +1,24 @@
+CREATE TABLE shares (
+    id SERIAL PRIMARY KEY,
+    token VARCHAR(100) UNIQUE NOT NULL,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    permission VARCHAR(20) NOT NULL DEFAULT 'download',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_shares_file_id ON shares(file_id);
+
+INSERT INTO shares (token, file_id, created_by, expires_at)
+SELECT share_token, id, user_id, expires_at FROM files
+WHERE is_public AND share_token IS NOT NULL AND share_token <> '';
+
+-- files.expires_at used to hold the share expiry, and the cleanup worker
+-- deleted the file itself once it passed. Expiry now belongs to each share.
+UPDATE files SET expires_at = NULL;
+
+DROP INDEX idx_files_share_token;
+ALTER TABLE files DROP COLUMN share_token, DROP COLUMN is_public;
@@ -0,0 +1,3 @@
+-- Seconds until a new share link expires when the request doesn't say.
+-- NULL falls back to the deployment default, 0 means links never expire.
+ALTER TABLE users ADD COLUMN default_share_expiry BIGINT;
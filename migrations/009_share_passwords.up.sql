@@ -0,0 +1 @@
+ALTER TABLE shares ADD COLUMN password_hash VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE shares
+    ADD COLUMN max_downloads INTEGER CHECK (max_downloads > 0),
+    ADD COLUMN download_count INTEGER NOT NULL DEFAULT 0;
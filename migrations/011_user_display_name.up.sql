@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN display_name VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,69 @@
+CREATE TABLE folders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES folders(id),
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_folders_name ON folders(user_id, COALESCE(parent_id, 0), name);
+CREATE INDEX idx_folders_parent_id ON folders(parent_id);
+
+ALTER TABLE files ADD COLUMN folder_id INTEGER REFERENCES folders(id);
+CREATE INDEX idx_files_folder_id ON files(folder_id);
+
+-- A grant gives another registered user a role on a file, or on a folder and
+-- everything below it.
+CREATE TABLE grants (
+    id SERIAL PRIMARY KEY,
+    file_id INTEGER REFERENCES files(id) ON DELETE CASCADE,
+    folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    grantee_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    granted_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('view', 'download', 'edit')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((file_id IS NULL) <> (folder_id IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_grants_file ON grants(file_id, grantee_id) WHERE file_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_grants_folder ON grants(folder_id, grantee_id) WHERE folder_id IS NOT NULL;
+CREATE INDEX idx_grants_grantee ON grants(grantee_id);
+
+CREATE FUNCTION role_rank(role VARCHAR) RETURNS INTEGER AS $$
+    SELECT CASE role WHEN 'owner' THEN 4 WHEN 'edit' THEN 3 WHEN 'download' THEN 2 WHEN 'view' THEN 1 ELSE 0 END
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+-- folder_role returns the strongest role a user holds on a folder, either as
+-- its owner or through a grant on the folder or any folder above it, and NULL
+-- when the user has no access.
+CREATE FUNCTION folder_role(p_folder_id INTEGER, p_user_id INTEGER) RETURNS VARCHAR AS $$
+    WITH RECURSIVE chain(id, parent_id) AS (
+        SELECT id, parent_id FROM folders WHERE id = p_folder_id
+        UNION
+        SELECT folders.id, folders.parent_id FROM folders JOIN chain ON folders.id = chain.parent_id
+    )
+    SELECT role FROM (
+        SELECT 'owner'::VARCHAR AS role FROM folders WHERE id = p_folder_id AND user_id = p_user_id
+        UNION ALL
+        SELECT grants.role FROM grants JOIN chain ON grants.folder_id = chain.id
+        WHERE grants.grantee_id = p_user_id
+    ) roles
+    ORDER BY role_rank(role) DESC
+    LIMIT 1
+$$ LANGUAGE sql STABLE;
+
+-- file_role is folder_role for files: ownership, a grant on the file itself,
+-- or whatever the user holds on the folder the file is in.
+CREATE FUNCTION file_role(p_file_id INTEGER, p_user_id INTEGER) RETURNS VARCHAR AS $$
+    SELECT role FROM (
+        SELECT 'owner'::VARCHAR AS role FROM files WHERE id = p_file_id AND user_id = p_user_id
+        UNION ALL
+        SELECT role FROM grants WHERE file_id = p_file_id AND grantee_id = p_user_id
+        UNION ALL
+        SELECT folder_role(folder_id, p_user_id) FROM files WHERE id = p_file_id AND folder_id IS NOT NULL
+    ) roles
+    WHERE role IS NOT NULL
+    ORDER BY role_rank(role) DESC
+    LIMIT 1
+$$ LANGUAGE sql STABLE;
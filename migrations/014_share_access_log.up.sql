@@ -0,0 +1,16 @@
+-- One row per request made through a share link, successful or not.
+-- Requests for unknown tokens aren't logged since they belong to no share.
+CREATE TABLE share_access_log (
+    id BIGSERIAL PRIMARY KEY,
+    share_id INTEGER NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    bytes_served BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_share_access_log_share ON share_access_log(share_id, created_at);
+CREATE INDEX idx_share_access_log_file ON share_access_log(file_id, created_at);
@@ -0,0 +1,21 @@
+-- A share link points at a single file, a folder with everything below it,
+-- or a selection of files listed in share_files.
+ALTER TABLE shares
+    ALTER COLUMN file_id DROP NOT NULL,
+    ADD COLUMN folder_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    ADD CONSTRAINT shares_single_target CHECK (file_id IS NULL OR folder_id IS NULL);
+
+CREATE INDEX idx_shares_folder_id ON shares(folder_id);
+CREATE INDEX idx_shares_created_by ON shares(created_by);
+
+CREATE TABLE share_files (
+    share_id INTEGER NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    PRIMARY KEY (share_id, file_id)
+);
+
+CREATE INDEX idx_share_files_file_id ON share_files(file_id);
+
+-- Requests to folder and selection links that don't concern one file, such
+-- as the listing page or the whole-set archive, are logged without a file.
+ALTER TABLE share_access_log ALTER COLUMN file_id DROP NOT NULL;
@@ -0,0 +1,24 @@
+-- A session is one login on one device. Its ID is the refresh token family
+-- ID and goes into every access token issued for it as the sid claim.
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+
+-- Logins from before sessions existed become sessions without device details.
+INSERT INTO sessions (id, user_id, created_at, last_seen_at, revoked_at)
+SELECT family_id, MIN(user_id), MIN(created_at), MAX(created_at),
+       CASE WHEN BOOL_AND(revoked_at IS NOT NULL) THEN MAX(revoked_at) END
+FROM refresh_tokens
+GROUP BY family_id;
+
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT refresh_tokens_family_id_fkey
+    FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
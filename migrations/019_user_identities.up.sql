@@ -0,0 +1,15 @@
+-- Logins through an external identity provider, identified by the provider's
+-- issuer and the subject it gives the user. Users created through single
+-- sign-on have an empty password_hash, which never matches a password.
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,16 @@
+-- TOTP two-factor authentication. totp_secret is set when enrollment starts
+-- and totp_enabled_at once the user has proven they can generate codes.
+-- totp_last_step is the time step of the last accepted code, so each code
+-- works only once.
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
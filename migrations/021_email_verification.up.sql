@@ -0,0 +1,21 @@
+-- Accounts that existed before verification was introduced are treated as
+-- verified, so requiring verification doesn't lock their owners out.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = created_at;
+
+-- Single-use tokens sent by email: purpose is 'verify_email' or
+-- 'reset_password'. email is the address the token was sent to, which must
+-- still be the user's when it is used.
+CREATE TABLE user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id);
+CREATE INDEX idx_user_tokens_expires_at ON user_tokens(expires_at);
@@ -0,0 +1,15 @@
+-- Security-relevant events, such as accounts locked after repeated failed
+-- logins. Events outlive the account they concern.
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    event VARCHAR(64) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_event_created_at ON audit_events(event, created_at);
@@ -0,0 +1,8 @@
+-- Profile settings. Empty strings mean not set: no avatar, and the client's
+-- own locale and time zone.
+ALTER TABLE users ADD COLUMN avatar_url VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+-- user_tokens gains the purpose 'change_email', whose email is the new
+-- address, to be confirmed before it replaces the old one.
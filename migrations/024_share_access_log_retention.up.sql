@@ -0,0 +1,2 @@
+-- Old entries are purged by age, see SHARE_ACCESS_LOG_RETENTION.
+CREATE INDEX idx_share_access_log_created_at ON share_access_log(created_at);